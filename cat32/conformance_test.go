@@ -0,0 +1,210 @@
+package cat32
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/bjwbell/gensimd/simd"
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+)
+
+/*
+conformanceTolerance is the largest difference allowed between a cat32 op
+and the same op in mat32.
+*/
+const conformanceTolerance = 1e-5
+
+/*
+conformanceResult holds how far a cat32 op got from the same op in mat32.
+*/
+type conformanceResult struct {
+	name        string
+	compared    int     // how many outputs and gradients were compared
+	maxAbsError float64 // worst difference, padding included, which must stay zero
+}
+
+/*
+conformer runs one op on the same inputs in both packages.
+*/
+type conformer struct {
+	r      *rand.Rand
+	result conformanceResult
+	cats   []*Mat
+	mats   []*mat32.Mat
+}
+
+/*
+input makes an n x d input for both packages, from the same random numbers.
+*/
+func (c *conformer) input(n int, d int) (*Mat, *mat32.Mat) {
+	w := make([]float32, n*d)
+	for i := range w {
+		w[i] = c.r.Float32()*2 - 1
+	}
+	cm := NewMat(n, d)
+	cm.SetFloats(w)
+	mm := mat32.NewMat(n, d)
+	copy(mm.W, w)
+	c.cats = append(c.cats, cm)
+	c.mats = append(c.mats, mm)
+	return cm, mm
+}
+
+/*
+check runs op both ways with backprop, seeds the same random gradient on
+both outputs, runs Backward and compares the outputs and every input's
+gradient.
+*/
+func (c *conformer) check(op func(g *Graph) *Mat, op32 func(g *mat32.Graph) *mat32.Mat) conformanceResult {
+	g := &Graph{}
+	g.ResetBackprop(true)
+	g32 := &mat32.Graph{}
+	g32.ResetBackprop(true)
+
+	out := op(g)
+	out32 := op32(g32)
+	Assert(out.RowCount == out32.RowCount && out.ColumnCount == out32.ColumnCount, "conformance output shapes differ")
+	c.compareMat(out, out.W, out32.W)
+
+	dw := make([]float32, len(out32.DW))
+	for i := range dw {
+		dw[i] = c.r.Float32()*2 - 1
+	}
+	out.SetGrads(dw)
+	copy(out32.DW, dw)
+	g.Backward()
+	g32.Backward()
+	for i, m := range c.cats {
+		c.compareMat(m, m.DW, c.mats[i].DW)
+	}
+	return c.result
+}
+
+/*
+compareMat folds the difference between the W or DW chunks of m and want,
+laid out like mat32, into the result. Padding lanes are compared to zero.
+*/
+func (c *conformer) compareMat(m *Mat, chunks []simd.F32x4, want []float32) {
+	Assert(len(want) == m.RowCount*m.ColumnCount, "conformance shapes differ")
+	for i := 0; i < m.RowCount; i++ {
+		for j := 0; j < m.Stride*4; j++ {
+			v := float64(chunks[i*m.Stride+j/4][j%4])
+			if j < m.ColumnCount {
+				v -= float64(want[i*m.ColumnCount+j])
+			}
+			c.result.maxAbsError = math.Max(c.result.maxAbsError, math.Abs(v))
+			c.result.compared++
+		}
+	}
+}
+
+/*
+conformance runs every cat32 op and mat32's version of it on the same random
+inputs, drawn from r, and returns how far apart their outputs and gradients
+got. The shapes have rows that do not fill their last chunk, so padding gets
+checked too. It runs on the kernels in use; see UseKernels.
+*/
+func conformance(r *rand.Rand) []conformanceResult {
+	var results []conformanceResult
+	run := func(name string, setup func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat)) {
+		c := &conformer{r: r, result: conformanceResult{name: name}}
+		op, op32 := setup(c)
+		results = append(results, c.check(op, op32))
+	}
+
+	run("RowPluck", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		m, m32 := c.input(5, 7)
+		return func(g *Graph) *Mat { return g.RowPluck(m, 3) },
+			func(g *mat32.Graph) *mat32.Mat { return g.RowPluck(m32, 3) }
+	})
+	run("Tanh", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		m, m32 := c.input(5, 7)
+		return func(g *Graph) *Mat { return g.Tanh(m) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Tanh(m32) }
+	})
+	run("Sigmoid", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		m, m32 := c.input(5, 7)
+		return func(g *Graph) *Mat { return g.Sigmoid(m) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Sigmoid(m32) }
+	})
+	run("Mul", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		a, a32 := c.input(5, 7)
+		b, b32 := c.input(7, 6)
+		return func(g *Graph) *Mat { return g.Mul(a, b) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Mul(a32, b32) }
+	})
+	run("MulVector", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		a, a32 := c.input(9, 5)
+		x, x32 := c.input(5, 1)
+		return func(g *Graph) *Mat { return g.Mul(a, x) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Mul(a32, x32) }
+	})
+	run("Add", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		a, a32 := c.input(5, 7)
+		b, b32 := c.input(5, 7)
+		return func(g *Graph) *Mat { return g.Add(a, b) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Add(a32, b32) }
+	})
+	run("Eltmul", func(c *conformer) (func(g *Graph) *Mat, func(g *mat32.Graph) *mat32.Mat) {
+		a, a32 := c.input(5, 7)
+		b, b32 := c.input(5, 7)
+		return func(g *Graph) *Mat { return g.Eltmul(a, b) },
+			func(g *mat32.Graph) *mat32.Mat { return g.Eltmul(a32, b32) }
+	})
+
+	// Softmax has no backprop, so only its output is compared
+	c := &conformer{r: r, result: conformanceResult{name: "Softmax"}}
+	m, m32 := c.input(6, 3)
+	out := Softmax(m)
+	c.compareMat(out, out.W, mat32.Softmax(m32).W)
+	results = append(results, c.result)
+
+	// RMSProp against the step ricur's StepSolver takes, in float64
+	c = &conformer{r: r, result: conformanceResult{name: "RMSProp"}}
+	w, _ := c.input(5, 7)
+	cache := NewMat(5, 7)
+	cache.SetFloats(w.Floats())
+	mul(floats(cache.W), floats(cache.W), floats(cache.W))
+	grads := make([]float32, 5*7)
+	for i := range grads {
+		grads[i] = (c.r.Float32()*2 - 1) * 8 // some past the clip
+	}
+	w.SetGrads(grads)
+	want := w.Floats()
+	wantCache := cache.Floats()
+	const decay, eps, step, regc, clip = 0.999, 1e-8, 0.01, 1e-6, 5
+	for i, g := range grads {
+		wantCache[i] = float32(decay*float64(wantCache[i]) + (1-decay)*float64(g)*float64(g))
+		g = float32(math.Max(-clip, math.Min(clip, float64(g))))
+		want[i] = float32(float64(want[i]) - step*float64(g)/math.Sqrt(float64(wantCache[i])+eps) - regc*float64(want[i]))
+	}
+	RMSProp(w, cache, decay, eps, step, regc, clip)
+	c.compareMat(w, w.W, want)
+	c.compareMat(cache, cache.W, wantCache)
+	c.compareMat(w, w.DW, make([]float32, len(want)))
+	results = append(results, c.result)
+
+	return results
+}
+
+/*
+TestConformance checks every cat32 op against mat32 with every set of
+kernels this CPU runs.
+*/
+func TestConformance(t *testing.T) {
+	defer UseKernels(Kernels())
+	for _, kernels := range KernelSets() {
+		if err := UseKernels(kernels); err != nil {
+			t.Fatal(err)
+		}
+		// the same inputs for every set of kernels
+		r := rand.New(rand.NewSource(1))
+		for _, result := range conformance(r) {
+			t.Logf("%s kernels: %-10s compared=%-6d maxAbsError=%.3e", kernels, result.name, result.compared, result.maxAbsError)
+			if !(result.maxAbsError <= conformanceTolerance) {
+				t.Errorf("%s kernels: %s is %.3e from mat32, over %.0e", kernels, result.name, result.maxAbsError, conformanceTolerance)
+			}
+		}
+	}
+}
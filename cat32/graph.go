@@ -38,29 +38,11 @@ func (g *Graph) AddBackprop(f func()) {
 }
 
 /*
-Backward runs all backpropagation functions, in order.
+Backward runs all backpropagation functions, last to first.
 */
 func (g *Graph) Backward() {
-	var wg sync.WaitGroup
-	totalBackprops := len(g.Backprop)
-	i := totalBackprops - 1
-	for i >= 0 {
-		fn := g.Backprop[i]
-		wasCreatedInGoneGoroutine := fn == nil
-		if wasCreatedInGoneGoroutine {
-			// Introduced in commit: 7807054d4594a08bdee0412a5782346c579a9b94
-			// Once we started matrix math in parallel during the forward
-			// pass, and some of the results were thrown out, we ended
-			// up with callback functions getting cleaned up, I think.
-			// Probably because the goroutine had been cleaned up, and
-			// the result was only used temporarily inside the goroutine.
-			// This is probably a Go runtime bug, mixed in with an unnecessary
-			// callback - nonetheless easily worked around.
-			wg.Done()
-			return
-		}
-		fn()
-		wg.Done()
+	for i := len(g.Backprop) - 1; i >= 0; i-- {
+		g.Backprop[i]()
 	}
 	g.Backprop = nil
 }
@@ -72,14 +54,15 @@ func (g *Graph) RowPluck(m *Mat, ix int) (out *Mat) {
 	Assert(ix >= 0 && ix < m.RowCount, "RowPluck invalid number of rows")
 
 	d := m.ColumnCount
-	n := d
 	out = NewMat(d, 1)
-	out.W = m.W[ix*d : ix*d+d]
+	for j := 0; j < d; j++ {
+		out.Set(j, 0, m.At(ix, j))
+	}
 
 	if g.NeedsBackprop {
 		backpropRowPluck := func() {
-			for j := 0; j < n; j++ {
-				m.DW[d*ix+j] = AddF32x4(m.DW[d*ix+j], out.DW[j])
+			for j := 0; j < d; j++ {
+				m.SetGrad(ix, j, m.GradAt(ix, j)+out.GradAt(j, 0))
 			}
 		}
 		g.AddBackprop(backpropRowPluck)
@@ -97,6 +80,7 @@ func (g *Graph) Tanh(m *Mat) *Mat {
 	f := 0
 	for ix := 0; ix < n; ix++ {
 		for f = 0; f < 4; f++ {
+			// tanh(0) is 0, so the padding stays zero
 			out.W[ix][f] = float32(math.Tanh(float64(m.W[ix][f])))
 		}
 	}
@@ -106,7 +90,7 @@ func (g *Graph) Tanh(m *Mat) *Mat {
 			for i := 0; i < n; i++ {
 				// grad for z = tanh(x) is (1 - z^2)
 				mwi := out.W[i]
-				m.DW[i] = AddF32x4(m.DW[i], SubF32x4(F32_1, SubF32x4(MulF32x4(mwi, mwi), out.DW[i])))
+				m.DW[i] = AddF32x4(m.DW[i], MulF32x4(SubF32x4(F32_1, MulF32x4(mwi, mwi)), out.DW[i]))
 			}
 		}
 		g.AddBackprop(backpropTahn)
@@ -131,11 +115,13 @@ func (g *Graph) Sigmoid(m *Mat) *Mat {
 		}
 		out.W[ix] = DivF32x4(F32_1, AddF32x4(F32_1, exps))
 	}
+	// sigmoid(0) is a half, not zero
+	out.clearPadding(out.W)
 
 	if g.NeedsBackprop {
 		backpropSigmoid := func() {
 			for i := 0; i < n; i++ {
-				// grad for z = tanh(x) is (1 - z^2)
+				// grad for z = sigmoid(x) is z * (1 - z)
 				mwi := out.W[i]
 				m.DW[i] = AddF32x4(m.DW[i], MulF32x4(mwi, MulF32x4(SubF32x4(F32_1, mwi), out.DW[i])))
 			}
@@ -148,40 +134,54 @@ func (g *Graph) Sigmoid(m *Mat) *Mat {
 
 /*
 Mul multiplies two matrices
+
+Each row of the output is a sum of rows of m2, scaled by the elements of the
+same row of m1, which matMul runs a whole padded row at a time. A column
+vector m2 has one element per chunk, so it gets packed into plain floats and
+goes through matVec instead.
 */
 func (g *Graph) Mul(m1 *Mat, m2 *Mat) *Mat {
 	Assert(m1.ColumnCount == m2.RowCount, "matmul dimensions misaligned")
 
 	n := m1.RowCount
+	k := m1.ColumnCount
 	d := m2.ColumnCount
+	lda := 4 * m1.Stride
+	ldb := 4 * m2.Stride
 	out := NewMat(n, d)
 
-	/* original */
-	for row := 0; row < m1.RowCount/4; row++ { // loop over rows of m1
-		for col := 0; col < m2.ColumnCount; col++ { // loop over cols of m2
-			cellSum := simd.F32x4{0, 0, 0, 0}
-			for colCell := 0; colCell < m1.ColumnCount; colCell++ { // dot product loop
-				cellSum = AddF32x4(cellSum, MulF32x4(m1.W[m1.ColumnCount*row+colCell], m2.W[m2.ColumnCount*colCell+col]))
-			}
-			out.W[d*row+col] = cellSum
-		}
+	var x []float32
+	if d == 1 {
+		x = m2.vector(m2.W)
+		y := make([]float32, n)
+		matVec(y, floats(m1.W), x, n, k, lda)
+		out.setVector(out.W, y)
+	} else {
+		matMul(floats(out.W), floats(m1.W), floats(m2.W), n, k, lda, ldb)
 	}
 
 	if g.NeedsBackprop {
 		backpropMul := func() {
-			for i := 0; i < m1.RowCount; i++ { // loop over rows of m1
-				// runtime overhead of parallelizing this with goroutines
-				// makes it slower - each backprop func has its own goroutine
-				// already.
-				for j := 0; j < m2.ColumnCount; j++ { // loop over cols of m2
-					for k := 0; k < m1.ColumnCount; k++ { // dot product loop
-						// reach back through `out` pointer
-						b := out.DW[m2.ColumnCount*i+j]
-						m1dw := m1.DW[m1.ColumnCount*i+k]
-						m2dw := m2.DW[m2.ColumnCount*k+j]
-						m1.DW[m1.ColumnCount*i+k] = AddF32x4(m1dw, MulF32x4(m2.W[m2.ColumnCount*k+j], b))
-						m2.DW[m2.ColumnCount*k+j] = AddF32x4(m2dw, MulF32x4(m1.W[m1.ColumnCount*i+k], b))
-					}
+			// runtime overhead of parallelizing this with goroutines
+			// makes it slower - each backprop func has its own goroutine
+			// already.
+			a, da := floats(m1.W), floats(m1.DW)
+			if d == 1 {
+				dy := out.vector(out.DW)
+				dx := make([]float32, k)
+				for i := 0; i < n; i++ {
+					axpy(dy[i], x, da[i*lda:i*lda+k])
+					axpy(dy[i], a[i*lda:i*lda+k], dx)
+				}
+				m2.addVector(m2.DW, dx)
+				return
+			}
+			b, db, dout := floats(m2.W), floats(m2.DW), floats(out.DW)
+			for i := 0; i < n; i++ { // loop over rows of m1
+				outGrad := dout[i*ldb : (i+1)*ldb]
+				for kk := 0; kk < k; kk++ {
+					da[i*lda+kk] += dot(outGrad, b[kk*ldb:(kk+1)*ldb])
+					axpy(a[i*lda+kk], outGrad, db[kk*ldb:(kk+1)*ldb])
 				}
 			}
 		}
@@ -194,22 +194,15 @@ func (g *Graph) Mul(m1 *Mat, m2 *Mat) *Mat {
 Add adds two matrices
 */
 func (g *Graph) Add(m1 *Mat, m2 *Mat) *Mat {
-	Assert(len(m1.W) == len(m2.W), "Cannot add arrays")
+	Assert(m1.RowCount == m2.RowCount && m1.ColumnCount == m2.ColumnCount, "Cannot add arrays")
 
 	out := NewMat(m1.RowCount, m1.ColumnCount)
-	ix := 0
-	n := len(m1.W)
-
-	for ; ix < n; ix++ {
-		out.W[ix] = AddF32x4(m1.W[ix], m2.W[ix])
-	}
+	add(floats(out.W), floats(m1.W), floats(m2.W))
 	if g.NeedsBackprop {
 		backpropAdd := func() {
-			last := len(m1.W)
-			for i := 0; i < last; i++ {
-				m1.DW[i] = AddF32x4(m1.DW[i], out.DW[i])
-				m2.DW[i] = AddF32x4(m2.DW[i], out.DW[i])
-			}
+			dout := floats(out.DW)
+			add(floats(m1.DW), floats(m1.DW), dout)
+			add(floats(m2.DW), floats(m2.DW), dout)
 		}
 		g.AddBackprop(backpropAdd)
 	}
@@ -220,21 +213,15 @@ func (g *Graph) Add(m1 *Mat, m2 *Mat) *Mat {
 Eltmul does element-wise multiplication
 */
 func (g *Graph) Eltmul(m1 *Mat, m2 *Mat) *Mat {
-	Assert(len(m1.W) == len(m2.W), "Cannot Eltmul")
+	Assert(m1.RowCount == m2.RowCount && m1.ColumnCount == m2.ColumnCount, "Cannot Eltmul")
 
 	out := NewMat(m1.RowCount, m1.ColumnCount)
-	ix := 0
-	n := len(m1.W)
-	for ; ix < n; ix++ {
-		out.W[ix] = MulF32x4(m1.W[ix], m2.W[ix])
-	}
+	mul(floats(out.W), floats(m1.W), floats(m2.W))
 	if g.NeedsBackprop {
 		backpropEtlmul := func() {
-			last := len(m1.W)
-			for i := 0; i < last; i++ {
-				m1.DW[i] = AddF32x4(m1.DW[i], MulF32x4(m2.W[i], out.DW[i]))
-				m2.DW[i] = AddF32x4(m2.DW[i], MulF32x4(m1.W[i], out.DW[i]))
-			}
+			dout := floats(out.DW)
+			mulAdd(floats(m1.DW), floats(m2.W), dout)
+			mulAdd(floats(m2.DW), floats(m1.W), dout)
 		}
 		g.AddBackprop(backpropEtlmul)
 	}
@@ -0,0 +1,216 @@
+package cat32
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/bjwbell/gensimd/simd"
+)
+
+/*
+kernelSet is one implementation of the loops the ops spend their time in.
+Every loop goes in one call, so the SIMD ones do not pay for a function call
+every 4 floats like AddF32x4 and friends do.
+
+The slices are plain floats - see floats - and the lengths are checked by the
+wrappers below, not by the kernels, which run off the end of a slice that is
+too short.
+*/
+type kernelSet struct {
+	name string
+	// dot is the sum of x[i] * y[i]
+	dot func(x, y []float32) float32
+	// axpy adds a * x to y
+	axpy func(a float32, x, y []float32)
+	// add is out = x + y, mul out = x * y, mulAdd out += x * y
+	add    func(out, x, y []float32)
+	mul    func(out, x, y []float32)
+	mulAdd func(out, x, y []float32)
+	// matVec is out[i] = the dot of x and row i of m, rows of ldm floats
+	matVec func(out, m, x []float32, rows, cols, ldm int)
+	// matMul adds a * b into out, where out and b have rows of ldb floats
+	matMul func(out, a, b []float32, n, k, lda, ldb int)
+	// rmsprop is one RMSProp step, see RMSProp
+	rmsprop func(w, dw, cache []float32, decay, eps, step, regc, clip float32)
+}
+
+var goKernels = &kernelSet{
+	name:    "go",
+	dot:     dotGo,
+	axpy:    axpyGo,
+	add:     addGo,
+	mul:     mulGo,
+	mulAdd:  mulAddGo,
+	matVec:  matVecGo,
+	matMul:  matMulGo,
+	rmsprop: rmspropGo,
+}
+
+/*
+kernelSets is every set this CPU can run, the fastest first. The assembly
+ones add themselves at init.
+*/
+var kernelSets = []*kernelSet{goKernels}
+
+/*
+kern is the set in use.
+*/
+var kern = goKernels
+
+/*
+Kernels is the name of the set of kernels in use: "avx2" for AVX2 and FMA,
+"sse", or "go" for plain Go.
+*/
+func Kernels() string {
+	return kern.name
+}
+
+/*
+KernelSets is the names of every set of kernels this CPU can run, the
+fastest, which is the one used by default, first.
+*/
+func KernelSets() []string {
+	names := make([]string, len(kernelSets))
+	for i, k := range kernelSets {
+		names[i] = k.name
+	}
+	return names
+}
+
+/*
+UseKernels switches every op over to the named set of kernels, to compare
+them. It is not safe to call while ops are running.
+*/
+func UseKernels(name string) error {
+	for _, k := range kernelSets {
+		if k.name == name {
+			kern = k
+			return nil
+		}
+	}
+	return fmt.Errorf("kernels %q do not run here, want one of %v", name, KernelSets())
+}
+
+/*
+floats is chunks as plain floats, 4 per chunk, sharing their memory.
+*/
+func floats(chunks []simd.F32x4) []float32 {
+	if len(chunks) == 0 {
+		return nil
+	}
+	return unsafe.Slice(&chunks[0][0], 4*len(chunks))
+}
+
+func dot(x, y []float32) float32 {
+	Assert(len(y) >= len(x), "dot y is shorter than x")
+	return kern.dot(x, y)
+}
+
+func axpy(a float32, x, y []float32) {
+	Assert(len(y) >= len(x), "axpy y is shorter than x")
+	kern.axpy(a, x, y)
+}
+
+func add(out, x, y []float32) {
+	Assert(len(x) >= len(out) && len(y) >= len(out), "add inputs are shorter than out")
+	kern.add(out, x, y)
+}
+
+func mul(out, x, y []float32) {
+	Assert(len(x) >= len(out) && len(y) >= len(out), "mul inputs are shorter than out")
+	kern.mul(out, x, y)
+}
+
+func mulAdd(out, x, y []float32) {
+	Assert(len(x) >= len(out) && len(y) >= len(out), "mulAdd inputs are shorter than out")
+	kern.mulAdd(out, x, y)
+}
+
+func matVec(out, m, x []float32, rows, cols, ldm int) {
+	Assert(cols <= ldm && len(out) >= rows && len(x) >= cols, "matVec shapes do not fit")
+	Assert(rows == 0 || len(m) >= (rows-1)*ldm+cols, "matVec m is too short")
+	kern.matVec(out, m, x, rows, cols, ldm)
+}
+
+func matMul(out, a, b []float32, n, k, lda, ldb int) {
+	Assert(k <= lda && len(a) >= n*lda && len(b) >= k*ldb && len(out) >= n*ldb, "matMul shapes do not fit")
+	kern.matMul(out, a, b, n, k, lda, ldb)
+}
+
+/*
+RMSProp is one RMSProp step on m, with its running average of squared
+gradients in cache, which must be the same shape:
+
+	cache = decay * cache + (1 - decay) * dw^2
+	w = w - step * clip(dw) / sqrt(cache + eps) - regc * w
+
+clip caps the gradient to [-clip, clip] after it goes into the cache. The
+gradients are zeroed for the next round.
+*/
+func RMSProp(m *Mat, cache *Mat, decay float32, eps float32, step float32, regc float32, clip float32) {
+	Assert(len(m.W) == len(cache.W) && len(m.DW) == len(m.W), "RMSProp cache does not match")
+	kern.rmsprop(floats(m.W), floats(m.DW), floats(cache.W), decay, eps, step, regc, clip)
+}
+
+func dotGo(x, y []float32) float32 {
+	var sum float32
+	for i, v := range x {
+		sum += v * y[i]
+	}
+	return sum
+}
+
+func axpyGo(a float32, x, y []float32) {
+	for i, v := range x {
+		y[i] += a * v
+	}
+}
+
+func addGo(out, x, y []float32) {
+	for i := range out {
+		out[i] = x[i] + y[i]
+	}
+}
+
+func mulGo(out, x, y []float32) {
+	for i := range out {
+		out[i] = x[i] * y[i]
+	}
+}
+
+func mulAddGo(out, x, y []float32) {
+	for i := range out {
+		out[i] += x[i] * y[i]
+	}
+}
+
+func matVecGo(out, m, x []float32, rows, cols, ldm int) {
+	for i := 0; i < rows; i++ {
+		out[i] = dotGo(m[i*ldm:i*ldm+cols], x)
+	}
+}
+
+func matMulGo(out, a, b []float32, n, k, lda, ldb int) {
+	for i := 0; i < n; i++ {
+		outRow := out[i*ldb : (i+1)*ldb]
+		for kk := 0; kk < k; kk++ {
+			axpyGo(a[i*lda+kk], b[kk*ldb:(kk+1)*ldb], outRow)
+		}
+	}
+}
+
+func rmspropGo(w, dw, cache []float32, decay, eps, step, regc, clip float32) {
+	for i := range w {
+		g := dw[i]
+		cache[i] = cache[i]*decay + (1-decay)*g*g
+		if g > clip {
+			g = clip
+		}
+		if g < -clip {
+			g = -clip
+		}
+		w[i] = w[i] - step*g/float32(math.Sqrt(float64(cache[i]+eps))) - regc*w[i]
+		dw[i] = 0
+	}
+}
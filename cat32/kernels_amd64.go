@@ -0,0 +1,118 @@
+//go:build gc && !noasm
+
+package cat32
+
+/*
+The kernels in kernels_amd64.s, in an AVX2 and FMA set and an SSE set. SSE
+is part of amd64, so that set always runs; AVX2 and FMA need asking the CPU,
+and the OS, which has to save the upper halves of the registers.
+*/
+
+//go:noescape
+func dotAVX2(x, y []float32) float32
+
+//go:noescape
+func axpyAVX2(a float32, x, y []float32)
+
+//go:noescape
+func addAVX2(out, x, y []float32)
+
+//go:noescape
+func mulAVX2(out, x, y []float32)
+
+//go:noescape
+func mulAddAVX2(out, x, y []float32)
+
+//go:noescape
+func matVecAVX2(out, m, x []float32, rows, cols, ldm int)
+
+//go:noescape
+func matMulAVX2(out, a, b []float32, n, k, lda, ldb int)
+
+//go:noescape
+func rmspropAVX2(w, dw, cache []float32, decay, eps, step, regc, clip float32)
+
+//go:noescape
+func dotSSE(x, y []float32) float32
+
+//go:noescape
+func axpySSE(a float32, x, y []float32)
+
+//go:noescape
+func addSSE(out, x, y []float32)
+
+//go:noescape
+func mulSSE(out, x, y []float32)
+
+//go:noescape
+func mulAddSSE(out, x, y []float32)
+
+//go:noescape
+func matVecSSE(out, m, x []float32, rows, cols, ldm int)
+
+//go:noescape
+func matMulSSE(out, a, b []float32, n, k, lda, ldb int)
+
+//go:noescape
+func rmspropSSE(w, dw, cache []float32, decay, eps, step, regc, clip float32)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+var avx2Kernels = &kernelSet{
+	name:    "avx2",
+	dot:     dotAVX2,
+	axpy:    axpyAVX2,
+	add:     addAVX2,
+	mul:     mulAVX2,
+	mulAdd:  mulAddAVX2,
+	matVec:  matVecAVX2,
+	matMul:  matMulAVX2,
+	rmsprop: rmspropAVX2,
+}
+
+var sseKernels = &kernelSet{
+	name:    "sse",
+	dot:     dotSSE,
+	axpy:    axpySSE,
+	add:     addSSE,
+	mul:     mulSSE,
+	mulAdd:  mulAddSSE,
+	matVec:  matVecSSE,
+	matMul:  matMulSSE,
+	rmsprop: rmspropSSE,
+}
+
+/*
+hasAVX2FMA is whether the CPU has AVX2 and FMA and the OS saves the YMM
+registers.
+*/
+func hasAVX2FMA() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	fma := ecx1&(1<<12) != 0
+	osxsave := ecx1&(1<<27) != 0
+	avx := ecx1&(1<<28) != 0
+	if !fma || !osxsave || !avx {
+		return false
+	}
+	// XMM and YMM state both enabled in XCR0
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	return ebx7&(1<<5) != 0
+}
+
+func init() {
+	sets := []*kernelSet{sseKernels}
+	if hasAVX2FMA() {
+		sets = append([]*kernelSet{avx2Kernels}, sets...)
+	}
+	kernelSets = append(sets, kernelSets...)
+	kern = kernelSets[0]
+}
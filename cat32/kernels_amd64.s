@@ -0,0 +1,922 @@
+//go:build gc && !noasm
+
+#include "textflag.h"
+
+// Whole-loop float32 kernels, see kernelSet in kernels.go. The AVX2 ones go
+// 8 floats at a time with FMA, the SSE ones 4, and both finish with a 4 wide
+// step and then one float at a time, so any length works. Dot products keep
+// two accumulators and add them at the end, which rounds a little
+// differently from a plain loop.
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// func dotAVX2(x, y []float32) float32
+TEXT ·dotAVX2(SB), NOSPLIT, $0-52
+	MOVQ x_base+0(FP), SI
+	MOVQ x_len+8(FP), CX
+	MOVQ y_base+24(FP), DX
+
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+dot16:
+	CMPQ CX, $16
+	JLT dot8
+	VMOVUPS (SI), Y2
+	VMOVUPS 32(SI), Y3
+	VFMADD231PS (DX), Y2, Y0
+	VFMADD231PS 32(DX), Y3, Y1
+	ADDQ $64, SI
+	ADDQ $64, DX
+	SUBQ $16, CX
+	JMP dot16
+dot8:
+	VADDPS Y1, Y0, Y0
+	CMPQ CX, $8
+	JLT dot4
+	VMOVUPS (SI), Y2
+	VFMADD231PS (DX), Y2, Y0
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+dot4:
+	VEXTRACTF128 $1, Y0, X1
+	VADDPS X1, X0, X0
+	CMPQ CX, $4
+	JLT dot1
+	VMOVUPS (SI), X2
+	VFMADD231PS (DX), X2, X0
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+dot1:
+	VHADDPS X0, X0, X0
+	VHADDPS X0, X0, X0
+dottail:
+	CMPQ CX, $0
+	JEQ dotdone
+	VMOVSS (SI), X2
+	VFMADD231SS (DX), X2, X0
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP dottail
+dotdone:
+	VZEROUPPER
+	VMOVSS X0, ret+48(FP)
+	RET
+
+// func axpyAVX2(a float32, x, y []float32)
+TEXT ·axpyAVX2(SB), NOSPLIT, $0-56
+	VBROADCASTSS a+0(FP), Y0
+	MOVQ x_base+8(FP), SI
+	MOVQ x_len+16(FP), CX
+	MOVQ y_base+32(FP), DI
+
+axpy16:
+	CMPQ CX, $16
+	JLT axpy8
+	VMOVUPS (DI), Y1
+	VMOVUPS 32(DI), Y2
+	VFMADD231PS (SI), Y0, Y1
+	VFMADD231PS 32(SI), Y0, Y2
+	VMOVUPS Y1, (DI)
+	VMOVUPS Y2, 32(DI)
+	ADDQ $64, SI
+	ADDQ $64, DI
+	SUBQ $16, CX
+	JMP axpy16
+axpy8:
+	CMPQ CX, $8
+	JLT axpy4
+	VMOVUPS (DI), Y1
+	VFMADD231PS (SI), Y0, Y1
+	VMOVUPS Y1, (DI)
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $8, CX
+axpy4:
+	CMPQ CX, $4
+	JLT axpytail
+	VMOVUPS (DI), X1
+	VFMADD231PS (SI), X0, X1
+	VMOVUPS X1, (DI)
+	ADDQ $16, SI
+	ADDQ $16, DI
+	SUBQ $4, CX
+axpytail:
+	CMPQ CX, $0
+	JEQ axpydone
+	VMOVSS (DI), X1
+	VFMADD231SS (SI), X0, X1
+	VMOVSS X1, (DI)
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP axpytail
+axpydone:
+	VZEROUPPER
+	RET
+
+// func addAVX2(out, x, y []float32)
+TEXT ·addAVX2(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop8:
+	CMPQ CX, $8
+	JLT loop4
+	VMOVUPS (SI), Y0
+	VADDPS (DX), Y0, Y0
+	VMOVUPS Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP loop8
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	VMOVUPS (SI), X0
+	VADDPS (DX), X0, X0
+	VMOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+tail:
+	CMPQ CX, $0
+	JEQ done
+	VMOVSS (SI), X0
+	VADDSS (DX), X0, X0
+	VMOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	VZEROUPPER
+	RET
+
+// func mulAVX2(out, x, y []float32)
+TEXT ·mulAVX2(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop8:
+	CMPQ CX, $8
+	JLT loop4
+	VMOVUPS (SI), Y0
+	VMULPS (DX), Y0, Y0
+	VMOVUPS Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP loop8
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	VMOVUPS (SI), X0
+	VMULPS (DX), X0, X0
+	VMOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+tail:
+	CMPQ CX, $0
+	JEQ done
+	VMOVSS (SI), X0
+	VMULSS (DX), X0, X0
+	VMOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	VZEROUPPER
+	RET
+
+// func mulAddAVX2(out, x, y []float32)
+TEXT ·mulAddAVX2(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop8:
+	CMPQ CX, $8
+	JLT loop4
+	VMOVUPS (DI), Y0
+	VMOVUPS (SI), Y1
+	VFMADD231PS (DX), Y1, Y0
+	VMOVUPS Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP loop8
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	VMOVUPS (DI), X0
+	VMOVUPS (SI), X1
+	VFMADD231PS (DX), X1, X0
+	VMOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+tail:
+	CMPQ CX, $0
+	JEQ done
+	VMOVSS (DI), X0
+	VMOVSS (SI), X1
+	VFMADD231SS (DX), X1, X0
+	VMOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	VZEROUPPER
+	RET
+
+// func matVecAVX2(out, m, x []float32, rows, cols, ldm int)
+TEXT ·matVecAVX2(SB), NOSPLIT, $0-96
+	MOVQ out_base+0(FP), DI
+	MOVQ m_base+24(FP), R8
+	MOVQ x_base+48(FP), R9
+	MOVQ rows+72(FP), R10
+	MOVQ cols+80(FP), R11
+	MOVQ ldm+88(FP), R12
+	SHLQ $2, R12
+row:
+	CMPQ R10, $0
+	JEQ done
+	MOVQ R8, SI
+	MOVQ R9, DX
+	MOVQ R11, CX
+
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+dot16:
+	CMPQ CX, $16
+	JLT dot8
+	VMOVUPS (SI), Y2
+	VMOVUPS 32(SI), Y3
+	VFMADD231PS (DX), Y2, Y0
+	VFMADD231PS 32(DX), Y3, Y1
+	ADDQ $64, SI
+	ADDQ $64, DX
+	SUBQ $16, CX
+	JMP dot16
+dot8:
+	VADDPS Y1, Y0, Y0
+	CMPQ CX, $8
+	JLT dot4
+	VMOVUPS (SI), Y2
+	VFMADD231PS (DX), Y2, Y0
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+dot4:
+	VEXTRACTF128 $1, Y0, X1
+	VADDPS X1, X0, X0
+	CMPQ CX, $4
+	JLT dot1
+	VMOVUPS (SI), X2
+	VFMADD231PS (DX), X2, X0
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+dot1:
+	VHADDPS X0, X0, X0
+	VHADDPS X0, X0, X0
+dottail:
+	CMPQ CX, $0
+	JEQ dotdone
+	VMOVSS (SI), X2
+	VFMADD231SS (DX), X2, X0
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP dottail
+dotdone:
+	VMOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ R12, R8
+	DECQ R10
+	JMP row
+done:
+	VZEROUPPER
+	RET
+
+// func matMulAVX2(out, a, b []float32, n, k, lda, ldb int)
+TEXT ·matMulAVX2(SB), NOSPLIT, $0-104
+	MOVQ out_base+0(FP), DI
+	MOVQ a_base+24(FP), R8
+	MOVQ n+72(FP), R10
+	MOVQ k+80(FP), R11
+	MOVQ lda+88(FP), R12
+	SHLQ $2, R12
+	MOVQ ldb+96(FP), R13
+row:
+	CMPQ R10, $0
+	JEQ done
+	MOVQ b_base+48(FP), R9
+	XORQ BX, BX
+inner:
+	CMPQ BX, R11
+	JEQ next
+	VBROADCASTSS (R8)(BX*4), Y0
+	MOVQ R9, SI
+	MOVQ DI, DX
+	MOVQ R13, CX
+
+axpy16:
+	CMPQ CX, $16
+	JLT axpy8
+	VMOVUPS (DX), Y1
+	VMOVUPS 32(DX), Y2
+	VFMADD231PS (SI), Y0, Y1
+	VFMADD231PS 32(SI), Y0, Y2
+	VMOVUPS Y1, (DX)
+	VMOVUPS Y2, 32(DX)
+	ADDQ $64, SI
+	ADDQ $64, DX
+	SUBQ $16, CX
+	JMP axpy16
+axpy8:
+	CMPQ CX, $8
+	JLT axpy4
+	VMOVUPS (DX), Y1
+	VFMADD231PS (SI), Y0, Y1
+	VMOVUPS Y1, (DX)
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+axpy4:
+	CMPQ CX, $4
+	JLT axpytail
+	VMOVUPS (DX), X1
+	VFMADD231PS (SI), X0, X1
+	VMOVUPS X1, (DX)
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+axpytail:
+	CMPQ CX, $0
+	JEQ axpydone
+	VMOVSS (DX), X1
+	VFMADD231SS (SI), X0, X1
+	VMOVSS X1, (DX)
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP axpytail
+axpydone:
+	LEAQ (R9)(R13*4), R9
+	INCQ BX
+	JMP inner
+next:
+	LEAQ (DI)(R13*4), DI
+	ADDQ R12, R8
+	DECQ R10
+	JMP row
+done:
+	VZEROUPPER
+	RET
+
+// func rmspropAVX2(w, dw, cache []float32, decay, eps, step, regc, clip float32)
+TEXT ·rmspropAVX2(SB), NOSPLIT, $0-92
+	VBROADCASTSS decay+72(FP), Y8
+	VBROADCASTSS eps+76(FP), Y9
+	VBROADCASTSS step+80(FP), Y10
+	VBROADCASTSS regc+84(FP), Y11
+	VBROADCASTSS clip+88(FP), Y12
+	MOVL $0x3f800000, AX
+	MOVQ AX, X13
+	VBROADCASTSS X13, Y13
+	VSUBPS Y8, Y13, Y13
+	VXORPS Y14, Y14, Y14
+	VSUBPS Y12, Y14, Y14
+	VXORPS Y7, Y7, Y7
+	MOVQ w_base+0(FP), DI
+	MOVQ w_len+8(FP), CX
+	MOVQ dw_base+24(FP), SI
+	MOVQ cache_base+48(FP), DX
+loop8:
+	CMPQ CX, $8
+	JLT loop4
+
+	VMOVUPS (SI), Y0
+	VMULPS Y0, Y0, Y2
+	VMULPS Y13, Y2, Y2
+	VMOVUPS (DX), Y1
+	VFMADD231PS Y8, Y1, Y2
+	VMOVUPS Y2, (DX)
+	VMAXPS Y14, Y0, Y0
+	VMINPS Y12, Y0, Y0
+	VADDPS Y9, Y2, Y3
+	VSQRTPS Y3, Y3
+	VMULPS Y10, Y0, Y0
+	VDIVPS Y3, Y0, Y0
+	VMOVUPS (DI), Y4
+	VMULPS Y11, Y4, Y5
+	VSUBPS Y0, Y4, Y4
+	VSUBPS Y5, Y4, Y4
+	VMOVUPS Y4, (DI)
+	VMOVUPS Y7, (SI)
+
+	ADDQ $32, DI
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP loop8
+loop4:
+	CMPQ CX, $4
+	JLT tail
+
+	VMOVUPS (SI), X0
+	VMULPS X0, X0, X2
+	VMULPS X13, X2, X2
+	VMOVUPS (DX), X1
+	VFMADD231PS X8, X1, X2
+	VMOVUPS X2, (DX)
+	VMAXPS X14, X0, X0
+	VMINPS X12, X0, X0
+	VADDPS X9, X2, X3
+	VSQRTPS X3, X3
+	VMULPS X10, X0, X0
+	VDIVPS X3, X0, X0
+	VMOVUPS (DI), X4
+	VMULPS X11, X4, X5
+	VSUBPS X0, X4, X4
+	VSUBPS X5, X4, X4
+	VMOVUPS X4, (DI)
+	VMOVUPS X7, (SI)
+
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+tail:
+	CMPQ CX, $0
+	JEQ done
+
+	VMOVSS (SI), X0
+	VMULSS X0, X0, X2
+	VMULSS X13, X2, X2
+	VMOVSS (DX), X1
+	VFMADD231SS X8, X1, X2
+	VMOVSS X2, (DX)
+	VMAXSS X14, X0, X0
+	VMINSS X12, X0, X0
+	VADDSS X9, X2, X3
+	VSQRTSS X3, X3, X3
+	VMULSS X10, X0, X0
+	VDIVSS X3, X0, X0
+	VMOVSS (DI), X4
+	VMULSS X11, X4, X5
+	VSUBSS X0, X4, X4
+	VSUBSS X5, X4, X4
+	VMOVSS X4, (DI)
+	VMOVSS X7, (SI)
+
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	VZEROUPPER
+	RET
+
+// func dotSSE(x, y []float32) float32
+TEXT ·dotSSE(SB), NOSPLIT, $0-52
+	MOVQ x_base+0(FP), SI
+	MOVQ x_len+8(FP), CX
+	MOVQ y_base+24(FP), DX
+
+	XORPS X0, X0
+	XORPS X1, X1
+dot8:
+	CMPQ CX, $8
+	JLT dot4
+	MOVUPS (SI), X2
+	MOVUPS (DX), X3
+	MULPS X3, X2
+	ADDPS X2, X0
+	MOVUPS 16(SI), X4
+	MOVUPS 16(DX), X5
+	MULPS X5, X4
+	ADDPS X4, X1
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP dot8
+dot4:
+	ADDPS X1, X0
+	CMPQ CX, $4
+	JLT dot1
+	MOVUPS (SI), X2
+	MOVUPS (DX), X3
+	MULPS X3, X2
+	ADDPS X2, X0
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+dot1:
+	MOVHLPS X0, X1
+	ADDPS X1, X0
+	MOVAPS X0, X1
+	SHUFPS $0x55, X1, X1
+	ADDSS X1, X0
+dottail:
+	CMPQ CX, $0
+	JEQ dotdone
+	MOVSS (SI), X2
+	MULSS (DX), X2
+	ADDSS X2, X0
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP dottail
+dotdone:
+	MOVSS X0, ret+48(FP)
+	RET
+
+// func axpySSE(a float32, x, y []float32)
+TEXT ·axpySSE(SB), NOSPLIT, $0-56
+	MOVSS a+0(FP), X0
+	SHUFPS $0x00, X0, X0
+	MOVQ x_base+8(FP), SI
+	MOVQ x_len+16(FP), CX
+	MOVQ y_base+32(FP), DI
+
+axpy4:
+	CMPQ CX, $4
+	JLT axpytail
+	MOVUPS (SI), X1
+	MULPS X0, X1
+	MOVUPS (DI), X2
+	ADDPS X1, X2
+	MOVUPS X2, (DI)
+	ADDQ $16, SI
+	ADDQ $16, DI
+	SUBQ $4, CX
+	JMP axpy4
+axpytail:
+	CMPQ CX, $0
+	JEQ axpydone
+	MOVSS (SI), X1
+	MULSS X0, X1
+	MOVSS (DI), X2
+	ADDSS X1, X2
+	MOVSS X2, (DI)
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP axpytail
+axpydone:
+	RET
+
+// func addSSE(out, x, y []float32)
+TEXT ·addSSE(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	MOVUPS (SI), X0
+	MOVUPS (DX), X1
+	ADDPS X1, X0
+	MOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+	JMP loop4
+tail:
+	CMPQ CX, $0
+	JEQ done
+	MOVSS (SI), X0
+	ADDSS (DX), X0
+	MOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	RET
+
+// func mulSSE(out, x, y []float32)
+TEXT ·mulSSE(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	MOVUPS (SI), X0
+	MOVUPS (DX), X1
+	MULPS X1, X0
+	MOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+	JMP loop4
+tail:
+	CMPQ CX, $0
+	JEQ done
+	MOVSS (SI), X0
+	MULSS (DX), X0
+	MOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	RET
+
+// func mulAddSSE(out, x, y []float32)
+TEXT ·mulAddSSE(SB), NOSPLIT, $0-72
+	MOVQ out_base+0(FP), DI
+	MOVQ out_len+8(FP), CX
+	MOVQ x_base+24(FP), SI
+	MOVQ y_base+48(FP), DX
+loop4:
+	CMPQ CX, $4
+	JLT tail
+	MOVUPS (SI), X1
+	MOVUPS (DX), X2
+	MULPS X2, X1
+	MOVUPS (DI), X0
+	ADDPS X1, X0
+	MOVUPS X0, (DI)
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+	JMP loop4
+tail:
+	CMPQ CX, $0
+	JEQ done
+	MOVSS (SI), X1
+	MULSS (DX), X1
+	MOVSS (DI), X0
+	ADDSS X1, X0
+	MOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	RET
+
+// func matVecSSE(out, m, x []float32, rows, cols, ldm int)
+TEXT ·matVecSSE(SB), NOSPLIT, $0-96
+	MOVQ out_base+0(FP), DI
+	MOVQ m_base+24(FP), R8
+	MOVQ x_base+48(FP), R9
+	MOVQ rows+72(FP), R10
+	MOVQ cols+80(FP), R11
+	MOVQ ldm+88(FP), R12
+	SHLQ $2, R12
+row:
+	CMPQ R10, $0
+	JEQ done
+	MOVQ R8, SI
+	MOVQ R9, DX
+	MOVQ R11, CX
+
+	XORPS X0, X0
+	XORPS X1, X1
+dot8:
+	CMPQ CX, $8
+	JLT dot4
+	MOVUPS (SI), X2
+	MOVUPS (DX), X3
+	MULPS X3, X2
+	ADDPS X2, X0
+	MOVUPS 16(SI), X4
+	MOVUPS 16(DX), X5
+	MULPS X5, X4
+	ADDPS X4, X1
+	ADDQ $32, SI
+	ADDQ $32, DX
+	SUBQ $8, CX
+	JMP dot8
+dot4:
+	ADDPS X1, X0
+	CMPQ CX, $4
+	JLT dot1
+	MOVUPS (SI), X2
+	MOVUPS (DX), X3
+	MULPS X3, X2
+	ADDPS X2, X0
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+dot1:
+	MOVHLPS X0, X1
+	ADDPS X1, X0
+	MOVAPS X0, X1
+	SHUFPS $0x55, X1, X1
+	ADDSS X1, X0
+dottail:
+	CMPQ CX, $0
+	JEQ dotdone
+	MOVSS (SI), X2
+	MULSS (DX), X2
+	ADDSS X2, X0
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP dottail
+dotdone:
+	MOVSS X0, (DI)
+	ADDQ $4, DI
+	ADDQ R12, R8
+	DECQ R10
+	JMP row
+done:
+	RET
+
+// func matMulSSE(out, a, b []float32, n, k, lda, ldb int)
+TEXT ·matMulSSE(SB), NOSPLIT, $0-104
+	MOVQ out_base+0(FP), DI
+	MOVQ a_base+24(FP), R8
+	MOVQ n+72(FP), R10
+	MOVQ k+80(FP), R11
+	MOVQ lda+88(FP), R12
+	SHLQ $2, R12
+	MOVQ ldb+96(FP), R13
+row:
+	CMPQ R10, $0
+	JEQ done
+	MOVQ b_base+48(FP), R9
+	XORQ BX, BX
+inner:
+	CMPQ BX, R11
+	JEQ next
+	MOVSS (R8)(BX*4), X0
+	SHUFPS $0x00, X0, X0
+	MOVQ R9, SI
+	MOVQ DI, DX
+	MOVQ R13, CX
+
+axpy4:
+	CMPQ CX, $4
+	JLT axpytail
+	MOVUPS (SI), X1
+	MULPS X0, X1
+	MOVUPS (DX), X2
+	ADDPS X1, X2
+	MOVUPS X2, (DX)
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+	JMP axpy4
+axpytail:
+	CMPQ CX, $0
+	JEQ axpydone
+	MOVSS (SI), X1
+	MULSS X0, X1
+	MOVSS (DX), X2
+	ADDSS X1, X2
+	MOVSS X2, (DX)
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP axpytail
+axpydone:
+	LEAQ (R9)(R13*4), R9
+	INCQ BX
+	JMP inner
+next:
+	LEAQ (DI)(R13*4), DI
+	ADDQ R12, R8
+	DECQ R10
+	JMP row
+done:
+	RET
+
+// func rmspropSSE(w, dw, cache []float32, decay, eps, step, regc, clip float32)
+TEXT ·rmspropSSE(SB), NOSPLIT, $0-92
+	MOVSS decay+72(FP), X8
+	SHUFPS $0x00, X8, X8
+	MOVSS eps+76(FP), X9
+	SHUFPS $0x00, X9, X9
+	MOVSS step+80(FP), X10
+	SHUFPS $0x00, X10, X10
+	MOVSS regc+84(FP), X11
+	SHUFPS $0x00, X11, X11
+	MOVSS clip+88(FP), X12
+	SHUFPS $0x00, X12, X12
+	MOVL $0x3f800000, AX
+	MOVQ AX, X13
+	SHUFPS $0x00, X13, X13
+	SUBPS X8, X13
+	XORPS X14, X14
+	SUBPS X12, X14
+	XORPS X7, X7
+	MOVQ w_base+0(FP), DI
+	MOVQ w_len+8(FP), CX
+	MOVQ dw_base+24(FP), SI
+	MOVQ cache_base+48(FP), DX
+loop4:
+	CMPQ CX, $4
+	JLT tail
+
+	MOVUPS (SI), X0
+	MOVAPS X0, X2
+	MULPS X0, X2
+	MULPS X13, X2
+	MOVUPS (DX), X1
+	MULPS X8, X1
+	ADDPS X1, X2
+	MOVUPS X2, (DX)
+	MAXPS X14, X0
+	MINPS X12, X0
+	MOVAPS X2, X3
+	ADDPS X9, X3
+	SQRTPS X3, X3
+	MULPS X10, X0
+	DIVPS X3, X0
+	MOVUPS (DI), X4
+	MOVAPS X4, X5
+	MULPS X11, X5
+	SUBPS X0, X4
+	SUBPS X5, X4
+	MOVUPS X4, (DI)
+	MOVUPS X7, (SI)
+
+	ADDQ $16, DI
+	ADDQ $16, SI
+	ADDQ $16, DX
+	SUBQ $4, CX
+	JMP loop4
+tail:
+	CMPQ CX, $0
+	JEQ done
+
+	MOVSS (SI), X0
+	MOVAPS X0, X2
+	MULSS X0, X2
+	MULSS X13, X2
+	MOVSS (DX), X1
+	MULSS X8, X1
+	ADDSS X1, X2
+	MOVSS X2, (DX)
+	MAXSS X14, X0
+	MINSS X12, X0
+	MOVAPS X2, X3
+	ADDSS X9, X3
+	SQRTSS X3, X3
+	MULSS X10, X0
+	DIVSS X3, X0
+	MOVSS (DI), X4
+	MOVAPS X4, X5
+	MULSS X11, X5
+	SUBSS X0, X4
+	SUBSS X5, X4
+	MOVSS X4, (DI)
+	MOVSS X7, (SI)
+
+	ADDQ $4, DI
+	ADDQ $4, SI
+	ADDQ $4, DX
+	DECQ CX
+	JMP tail
+done:
+	RET
@@ -0,0 +1,189 @@
+package cat32
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+/*
+maxKernelLength is the longest slice the kernels get checked with, past
+several rounds of the 16-wide unrolled loops with every kind of tail.
+*/
+const maxKernelLength = 300
+
+/*
+guard is how many floats past the end of every slice the kernels get,
+filled with guardValue, which they must leave alone.
+*/
+const guard = 8
+
+var guardValue = float32(math.NaN())
+
+/*
+kernelSlice is n random floats in [-1, 1), followed by the guard.
+*/
+func kernelSlice(r *rand.Rand, n int) []float32 {
+	s := make([]float32, n+guard)
+	for i := range s[:n] {
+		s[i] = r.Float32()*2 - 1
+	}
+	for i := n; i < len(s); i++ {
+		s[i] = guardValue
+	}
+	return s
+}
+
+/*
+checkGuard fails when anything after the first n floats of s was written.
+*/
+func checkGuard(t *testing.T, name string, s []float32, n int) {
+	t.Helper()
+	for i := n; i < len(s); i++ {
+		if !math.IsNaN(float64(s[i])) {
+			t.Fatalf("%s wrote %v past the end, at %d of %d", name, s[i], i, n)
+		}
+	}
+}
+
+/*
+checkNear fails when got is further than tol from want, element by element.
+*/
+func checkNear(t *testing.T, name string, got []float32, want []float32, tol func(i int) float64) {
+	t.Helper()
+	for i := range want {
+		if d := math.Abs(float64(got[i]) - float64(want[i])); d > tol(i) || math.IsNaN(d) {
+			t.Fatalf("%s: element %d is %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+/*
+roundings is the tolerance of a sum of n products of about scale in size
+each, added up in another order, or fused.
+*/
+func roundings(n int, scale float64) float64 {
+	return float64(n+1) * 1.2e-7 * scale
+}
+
+func clone(s []float32) []float32 {
+	return append([]float32(nil), s...)
+}
+
+func testElementwise(t *testing.T, k *kernelSet, r *rand.Rand, n int) {
+	x, y := kernelSlice(r, n), kernelSlice(r, n)
+	name := func(kernel string) string { return fmt.Sprintf("%s %s n=%d", k.name, kernel, n) }
+
+	got, want := k.dot(x[:n], y[:n]), dotGo(x[:n], y[:n])
+	var scale float64
+	for i := range x[:n] {
+		scale += math.Abs(float64(x[i] * y[i]))
+	}
+	checkNear(t, name("dot"), []float32{got}, []float32{want}, func(int) float64 { return roundings(n, scale) })
+
+	a := r.Float32()*2 - 1
+	gotY, wantY := clone(y), clone(y)
+	k.axpy(a, x[:n], gotY[:n])
+	axpyGo(a, x[:n], wantY[:n])
+	checkGuard(t, name("axpy"), gotY, n)
+	checkNear(t, name("axpy"), gotY[:n], wantY[:n], func(int) float64 { return roundings(1, 2) })
+
+	for _, kernel := range []struct {
+		name     string
+		got, one func(out, x, y []float32)
+	}{
+		{"add", k.add, addGo},
+		{"mul", k.mul, mulGo},
+		{"mulAdd", k.mulAdd, mulAddGo},
+	} {
+		// out starts out random, for mulAdd to add to
+		out := kernelSlice(r, n)
+		gotOut, wantOut := clone(out), clone(out)
+		kernel.got(gotOut[:n], x, y)
+		kernel.one(wantOut[:n], x, y)
+		checkGuard(t, name(kernel.name), gotOut, n)
+		checkNear(t, name(kernel.name), gotOut[:n], wantOut[:n], func(int) float64 { return roundings(1, 2) })
+	}
+
+	// RMSProp with gradients past the clip, and a cache that is positive
+	w, dw, cache := kernelSlice(r, n), kernelSlice(r, n), kernelSlice(r, n)
+	for i := 0; i < n; i++ {
+		dw[i] *= 8
+		cache[i] *= cache[i]
+	}
+	gotW, gotDW, gotCache := clone(w), clone(dw), clone(cache)
+	wantW, wantDW, wantCache := clone(w), clone(dw), clone(cache)
+	const decay, eps, step, regc, clip = 0.999, 1e-8, 0.01, 1e-6, 5
+	k.rmsprop(gotW[:n], gotDW[:n], gotCache[:n], decay, eps, step, regc, clip)
+	rmspropGo(wantW[:n], wantDW[:n], wantCache[:n], decay, eps, step, regc, clip)
+	checkGuard(t, name("rmsprop w"), gotW, n)
+	checkGuard(t, name("rmsprop dw"), gotDW, n)
+	checkGuard(t, name("rmsprop cache"), gotCache, n)
+	checkNear(t, name("rmsprop cache"), gotCache[:n], wantCache[:n], func(i int) float64 {
+		return roundings(2, float64(wantCache[i]))
+	})
+	// the step divides by sqrt(cache), so it carries the cache's rounding
+	checkNear(t, name("rmsprop w"), gotW[:n], wantW[:n], func(i int) float64 {
+		return roundings(2, 1) + 1e-5*step*clip/math.Sqrt(float64(wantCache[i])+eps)
+	})
+	checkNear(t, name("rmsprop dw"), gotDW[:n], wantDW[:n], func(int) float64 { return 0 })
+}
+
+func testMatVec(t *testing.T, k *kernelSet, r *rand.Rand, cols int) {
+	x := kernelSlice(r, cols)
+	for _, rows := range []int{0, 1, 3, 17} {
+		// odd leading dimensions, as well as packed rows
+		for _, pad := range []int{0, 1, 3, 6} {
+			ldm := cols + pad
+			m := kernelSlice(r, rows*ldm)
+			got, want := kernelSlice(r, rows), kernelSlice(r, rows)
+			k.matVec(got[:rows], m, x, rows, cols, ldm)
+			matVecGo(want[:rows], m, x, rows, cols, ldm)
+			name := fmt.Sprintf("%s matVec %dx%d ldm=%d", k.name, rows, cols, ldm)
+			checkGuard(t, name, got, rows)
+			checkNear(t, name, got[:rows], want[:rows], func(int) float64 { return roundings(cols, 1) })
+		}
+	}
+}
+
+func testMatMul(t *testing.T, k *kernelSet, r *rand.Rand, ldb int) {
+	for _, n := range []int{0, 1, 3} {
+		for _, inner := range []int{0, 1, 5} {
+			for _, pad := range []int{0, 3} {
+				lda := inner + pad
+				a := kernelSlice(r, n*lda)
+				b := kernelSlice(r, inner*ldb)
+				// matMul adds into out, so it starts out random
+				out := kernelSlice(r, n*ldb)
+				got, want := clone(out), clone(out)
+				k.matMul(got[:n*ldb], a, b, n, inner, lda, ldb)
+				matMulGo(want[:n*ldb], a, b, n, inner, lda, ldb)
+				name := fmt.Sprintf("%s matMul %dx%d ldb=%d lda=%d", k.name, n, inner, ldb, lda)
+				checkGuard(t, name, got, n*ldb)
+				checkNear(t, name, got[:n*ldb], want[:n*ldb], func(int) float64 { return roundings(inner+1, 1) })
+			}
+		}
+	}
+}
+
+/*
+TestKernels checks every set of kernels this CPU runs against the plain Go
+ones, at every length up to maxKernelLength, so each unrolled loop and each
+tail gets run, and checks they write nothing past the end.
+*/
+func TestKernels(t *testing.T) {
+	for _, k := range kernelSets {
+		if k == goKernels {
+			continue
+		}
+		t.Run(k.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			for n := 0; n <= maxKernelLength; n++ {
+				testElementwise(t, k, r, n)
+				testMatVec(t, k, r, n)
+				testMatMul(t, k, r, n)
+			}
+		})
+	}
+}
@@ -1,59 +1,205 @@
 package cat32
 
 import (
-	"math"
+	"fmt"
 
 	"github.com/bjwbell/gensimd/simd"
 )
 
 /*
-Mat holds a matrix. It is in chunks of 4.
+Mat holds a matrix. It is in chunks of 4: every row starts on a new chunk and
+takes Stride of them, so a row of 5 columns is 2 chunks with 3 lanes of
+padding. The padding is always zero, in W and DW, and ops keep it that way -
+it is what lets them run whole chunks without caring where a row ends.
 */
 type Mat struct {
 	RowCount    int
 	ColumnCount int
+	Stride      int // chunks per row
 	W           []simd.F32x4
 	DW          []simd.F32x4
 }
 
 /*
-Value steps through the array of float32 chunks and returns
-the value at the requested index.
+chunk is where element i, j of m lives: the chunk, and the lane in it.
+*/
+func (m *Mat) chunk(i int, j int) (int, int) {
+	return i*m.Stride + j/4, j % 4
+}
+
+/*
+At is the weight at row i, column j.
+*/
+func (m *Mat) At(i int, j int) float32 {
+	c, f := m.chunk(i, j)
+	return m.W[c][f]
+}
+
+/*
+Set sets the weight at row i, column j.
+*/
+func (m *Mat) Set(i int, j int, v float32) {
+	c, f := m.chunk(i, j)
+	m.W[c][f] = v
+}
+
+/*
+GradAt is the gradient at row i, column j.
+*/
+func (m *Mat) GradAt(i int, j int) float32 {
+	c, f := m.chunk(i, j)
+	return m.DW[c][f]
+}
+
+/*
+SetGrad sets the gradient at row i, column j.
+*/
+func (m *Mat) SetGrad(i int, j int, v float32) {
+	c, f := m.chunk(i, j)
+	m.DW[c][f] = v
+}
+
+/*
+Value is the weight at index, counting row by row the way mat32 lays out W.
 */
 func (m *Mat) Value(index int) (val float32) {
-	cursor := 0
-	for i := 0; i < len(m.W); i++ {
-		for f := 0; f < 4; f++ {
-			if cursor >= index {
-				val = m.W[i][f]
-				break
-			}
-			cursor++
+	Assert(index >= 0 && index < m.RowCount*m.ColumnCount, "Value index out of range")
+	return m.At(index/m.ColumnCount, index%m.ColumnCount)
+}
+
+/*
+Floats is a copy of the weights without the padding, row by row.
+*/
+func (m *Mat) Floats() []float32 {
+	return unpad(m, m.W)
+}
+
+/*
+Grads is a copy of the gradients without the padding, row by row.
+*/
+func (m *Mat) Grads() []float32 {
+	return unpad(m, m.DW)
+}
+
+func unpad(m *Mat, chunks []simd.F32x4) []float32 {
+	out := make([]float32, 0, m.RowCount*m.ColumnCount)
+	for i := 0; i < m.RowCount; i++ {
+		for j := 0; j < m.ColumnCount; j++ {
+			c, f := m.chunk(i, j)
+			out = append(out, chunks[c][f])
 		}
 	}
-	return val
+	return out
 }
 
-func zeros(size int) (m []simd.F32x4) {
-	extra := 0
-	if size%4 != 0 {
-		extra++
+/*
+SetFloats sets the weights from w, row by row without padding.
+*/
+func (m *Mat) SetFloats(w []float32) {
+	Assert(len(w) == m.RowCount*m.ColumnCount, "SetFloats needs one value per element")
+	for ix, v := range w {
+		m.Set(ix/m.ColumnCount, ix%m.ColumnCount, v)
 	}
-	// no need to initialize zero values
-	m = make([]simd.F32x4, int(math.Max(1, float64(size/4)))+extra)
-	return m
 }
 
 /*
-NewMat instantiates a new matrix.
+SetGrads sets the gradients from dw, row by row without padding.
+*/
+func (m *Mat) SetGrads(dw []float32) {
+	Assert(len(dw) == m.RowCount*m.ColumnCount, "SetGrads needs one value per element")
+	for ix, v := range dw {
+		m.SetGrad(ix/m.ColumnCount, ix%m.ColumnCount, v)
+	}
+}
+
+/*
+vector is the column of an n x 1 m, from its W or DW chunks, packed into
+plain floats.
+*/
+func (m *Mat) vector(chunks []simd.F32x4) []float32 {
+	Assert(m.ColumnCount == 1, "vector of a Mat with more than one column")
+	v := make([]float32, m.RowCount)
+	for i := range v {
+		v[i] = chunks[i*m.Stride][0]
+	}
+	return v
+}
+
+/*
+setVector writes v into the column of an n x 1 m's W or DW chunks.
+*/
+func (m *Mat) setVector(chunks []simd.F32x4, v []float32) {
+	Assert(m.ColumnCount == 1 && len(v) == m.RowCount, "setVector does not fit")
+	for i, x := range v {
+		chunks[i*m.Stride][0] = x
+	}
+}
+
+/*
+addVector adds v into the column of an n x 1 m's W or DW chunks.
+*/
+func (m *Mat) addVector(chunks []simd.F32x4, v []float32) {
+	Assert(m.ColumnCount == 1 && len(v) == m.RowCount, "addVector does not fit")
+	for i, x := range v {
+		chunks[i*m.Stride][0] += x
+	}
+}
+
+/*
+clearPadding zeroes the padding lanes of chunks, for after an op that does
+not map zero to zero.
+*/
+func (m *Mat) clearPadding(chunks []simd.F32x4) {
+	used := m.ColumnCount % 4
+	if used == 0 {
+		return
+	}
+	for i := 0; i < m.RowCount; i++ {
+		last := &chunks[(i+1)*m.Stride-1]
+		for f := used; f < 4; f++ {
+			last[f] = 0
+		}
+	}
+}
+
+/*
+NewMat instantiates a new n x d matrix.
 */
 func NewMat(n int, d int) (m *Mat) {
-	m = &Mat{RowCount: n, ColumnCount: int(math.Max(1, float64(d/4)))}
-	m.W = zeros(n * d)
-	m.DW = zeros(n * d)
+	stride := (d + 3) / 4
+	m = &Mat{RowCount: n, ColumnCount: d, Stride: stride}
+	// no need to initialize zero values
+	m.W = make([]simd.F32x4, n*stride)
+	m.DW = make([]simd.F32x4, n*stride)
 	return m
 }
 
+/*
+Validate tells what is wrong with the layout of m, if anything, like a Mat
+from a model saved before rows had a Stride. Those had the chunks of a row as
+their ColumnCount, and rows running on into each other.
+*/
+func (m *Mat) Validate() error {
+	if m.RowCount < 0 || m.ColumnCount < 0 || m.Stride != (m.ColumnCount+3)/4 {
+		return fmt.Errorf("a %d x %d Mat with %d chunks a row is not the current layout", m.RowCount, m.ColumnCount, m.Stride)
+	}
+	if len(m.W) != m.RowCount*m.Stride || len(m.DW) != len(m.W) {
+		return fmt.Errorf("a %d x %d Mat has %d chunks of weights and %d of gradients, want %d", m.RowCount, m.ColumnCount, len(m.W), len(m.DW), m.RowCount*m.Stride)
+	}
+	used := m.ColumnCount % 4
+	if used == 0 {
+		return nil
+	}
+	for i := 0; i < m.RowCount; i++ {
+		for _, v := range m.W[(i+1)*m.Stride-1][used:] {
+			if v != 0 {
+				return fmt.Errorf("a %d x %d Mat has weights in the padding of row %d", m.RowCount, m.ColumnCount, i)
+			}
+		}
+	}
+	return nil
+}
+
 /*
 RandMat fills a Mat with random numbers and returns it.
 */
@@ -69,6 +215,7 @@ func RandMat(n int, d int, std float32) (m *Mat) {
 			float32(Randf(-std, std)),
 		}
 	}
+	m.clearPadding(m.W)
 
 	return m
 }
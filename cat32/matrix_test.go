@@ -0,0 +1,46 @@
+package cat32
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bjwbell/gensimd/simd"
+)
+
+/*
+TestValidate checks Mats saved and loaded back pass, and ones saved before
+rows had a Stride do not.
+*/
+func TestValidate(t *testing.T) {
+	for _, size := range [][2]int{{3, 5}, {4, 8}, {1, 1}} {
+		m := RandMat(size[0], size[1], 0.08)
+		s, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded := &Mat{}
+		if err = json.Unmarshal(s, loaded); err != nil {
+			t.Fatal(err)
+		}
+		if err = loaded.Validate(); err != nil {
+			t.Errorf("%d x %d: %v", size[0], size[1], err)
+		}
+	}
+
+	// a 3 x 5 Mat the way they used to be: 5/4 columns, no Stride, and 15
+	// floats in 4 chunks
+	old := &Mat{RowCount: 3, ColumnCount: 1, W: make([]simd.F32x4, 4), DW: make([]simd.F32x4, 4)}
+	if old.Validate() == nil {
+		t.Errorf("a Mat without a Stride is valid")
+	}
+	short := NewMat(3, 5)
+	short.W = short.W[:5]
+	if short.Validate() == nil {
+		t.Errorf("a Mat missing a chunk is valid")
+	}
+	padded := NewMat(3, 5)
+	padded.W[3][2] = 1
+	if padded.Validate() == nil {
+		t.Errorf("a Mat with weights in its padding is valid")
+	}
+}
@@ -2,41 +2,32 @@ package cat32
 
 import (
 	"math"
-
-	"github.com/bjwbell/gensimd/simd"
 )
 
 /*
-Softmax computes the softmax of a matrix, I guess.
+Softmax computes the softmax of a matrix, I guess. Each column is its own
+distribution, like mat32.Softmax.
 */
 func Softmax(m *Mat) *Mat {
 	out := NewMat(m.RowCount, m.ColumnCount) // probability volume
-	var maxval float32 = -999999.0
-	i := 0
-	n := len(m.W)
-	f := 0
 
-	for ; i < n; i++ {
-		for f = 0; f < 4; f++ {
-			if m.W[i][f] > maxval {
-				maxval = m.W[i][f]
+	for j := 0; j < m.ColumnCount; j++ {
+		var maxval float32 = -999999.0
+		for i := 0; i < m.RowCount; i++ {
+			if v := m.At(i, j); v > maxval {
+				maxval = v
 			}
 		}
-	}
 
-	var s float32 = 0.0
-	i = 0
-	for ; i < n; i++ {
-		for f = 0; f < 4; f++ {
-			out.W[i][f] = float32(math.Exp(float64(m.W[i][f]) - float64(maxval)))
-			s += out.W[i][f]
+		var s float32 = 0.0
+		for i := 0; i < m.RowCount; i++ {
+			e := float32(math.Exp(float64(m.At(i, j)) - float64(maxval)))
+			out.Set(i, j, e)
+			s += e
 		}
-	}
 
-	i = 0
-	for ; i < n; i++ {
-		for f = 0; f < 4; f++ {
-			out.W[i][f] /= s
+		for i := 0; i < m.RowCount; i++ {
+			out.Set(i, j, out.At(i, j)/s)
 		}
 	}
 
@@ -50,20 +41,18 @@ func Softmax(m *Mat) *Mat {
 SampleArgmaxI does something with sampling and integers, maybe.
 
 Old comment: sample argmax from w, assuming w are probabilities that sum to one
+
+It returns the index of an element of m, counted the way Value counts them.
 */
-func SampleArgmaxI(w []simd.F32x4) int {
+func SampleArgmaxI(m *Mat) int {
 	r := Randf(0, 1)
 	var x float32 = 0.0
-	i := 0
-	f := 0
-	for {
-		for f = 0; f < 4; f++ {
-			x += w[i][f]
-			if x > r {
-				return i
-			}
+	n := m.RowCount * m.ColumnCount
+	for i := 0; i < n; i++ {
+		x += m.Value(i)
+		if x > r {
+			return i
 		}
-		i++
 	}
-	return len(w) - 1
+	return n - 1
 }
@@ -60,15 +60,15 @@ func (state *TrainingState) CostFunction(sent string) Cost {
 		probs = cat32.Softmax(lh.Output) // compute the softmax probabilities
 
 		// all done? END?
-		if (len(probs.W) - 1) < ixTarget {
+		if (probs.RowCount - 1) < ixTarget {
 			break
 		}
 		log2ppl += -math.Log2(float64(probs.Value(ixTarget))) // accumulate base 2 log prob and do smoothing
 		cost += -math.Log(float64(probs.Value(ixTarget)))
 
 		// write gradients into log probabilities
-		lh.Output.DW = probs.W
-		lh.Output.DW[ixTarget] = cat32.SubF32x4(lh.Output.DW[ixTarget], cat32.F32_1)
+		copy(lh.Output.DW, probs.W)
+		lh.Output.SetGrad(ixTarget, 0, lh.Output.GradAt(ixTarget, 0)-1)
 
 		prev = lh
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/bjwbell/gensimd/simd"
 	"github.com/getlantern/errors"
 	"github.com/pkg/profile"
+	"github.com/ruffrey/recurrent-nn-char-go/cat32"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -184,16 +185,10 @@ func main() {
 				if loadFilepath == "" {
 					return errors.New("Missing required filepath to model: --load")
 				}
-				s, err := ioutil.ReadFile(loadFilepath)
+				state, err := loadState(loadFilepath)
 				if err != nil {
 					return err
 				}
-				state := &TrainingState{}
-				err = json.Unmarshal(s, state)
-				if err != nil {
-					fmt.Println("state=", state)
-					return err
-				}
 
 				sentences := strings.Split(c.String("seed"), "\n")
 				solver := NewSolver()
@@ -212,7 +207,30 @@ func main() {
 		},
 	}
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+/*
+loadState reads the TrainingState saved to filename.
+*/
+func loadState(filename string) (*TrainingState, error) {
+	s, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	state := &TrainingState{}
+	err = json.Unmarshal(s, state)
+	if err != nil {
+		fmt.Println("state=", state)
+		return nil, err
+	}
+	if err = state.validate(); err != nil {
+		return nil, fmt.Errorf("%s was saved by an older version and cannot be loaded: %v", filename, err)
+	}
+	return state, nil
 }
 
 func training(inputSeed string, inputFile string, loadFilepath string, saveFilepath string, defaultHiddenLayers []int) (err error) {
@@ -229,20 +247,15 @@ func training(inputSeed string, inputFile string, loadFilepath string, saveFilep
 	fmt.Println("  regularization=", regc)
 	fmt.Println("  gradient clip=", clipval)
 	fmt.Println("  sequence length=", sequenceLength)
+	fmt.Println("  kernels=", cat32.Kernels())
 
 	// this is where the training state is held in memory, not in global scope
 	// most importantly, to prevent leaks.
 	// (could also fetch from disk)
 	var state *TrainingState
 	if loadFilepath != "" {
-		s, err := ioutil.ReadFile(loadFilepath)
-		if err != nil {
-			return err
-		}
-		state = &TrainingState{}
-		err = json.Unmarshal(s, state)
+		state, err = loadState(loadFilepath)
 		if err != nil {
-			fmt.Println("state=", state)
 			return err
 		}
 		fmt.Println("Loaded network\n ", state.HiddenSizes)
@@ -36,6 +36,35 @@ type TrainingState struct {
 	TickIterator  int      `json:"-"`
 }
 
+/*
+validate checks every Mat the state was loaded with has the current layout,
+so an older model is turned down instead of read wrong.
+*/
+func (state *TrainingState) validate() error {
+	mats := map[string]*cat32.Mat{}
+	for k, m := range state.Model {
+		mats[k] = m
+	}
+	for k, m := range state.Solver.StepCache {
+		mats["step cache of "+k] = m
+	}
+	for d := range state.HiddenPrevs {
+		mats["HiddenPrevs "+strconv.Itoa(d)] = state.HiddenPrevs[d]
+	}
+	for d := range state.CellPrevs {
+		mats["CellPrevs "+strconv.Itoa(d)] = state.CellPrevs[d]
+	}
+	for k, m := range mats {
+		if m == nil {
+			continue
+		}
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return nil
+}
+
 /*
 InitVocab helps initialize this instance's vocab array.
 */
@@ -344,9 +373,9 @@ func (state *TrainingState) PredictSentence(maxCharsGenerate int, seedString str
 		logrithmicProbabilities := lh.Output
 		probs := cat32.Softmax(logrithmicProbabilities)
 
-		ixSource = cat32.SampleArgmaxI(probs.W)
+		ixSource = cat32.SampleArgmaxI(probs)
 
-		if ixSource == 0 || ixSource == len(probs.W) {
+		if ixSource == 0 || ixSource == probs.RowCount {
 			break // start or end token predicted, break out
 		}
 		if len(s) > maxCharsGenerate {
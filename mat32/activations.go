@@ -0,0 +1,264 @@
+package mat32
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+Gelu is the Gaussian error linear unit x * Phi(x), with the exact erf.
+*/
+func (g *GraphOf[T]) Gelu(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpGelu, m)
+}
+
+func forwardGelu[T Float](op *TapeOp[T]) {
+	for i, x := range op.Inputs[0].W {
+		op.Output.W[i] = T(float64(x) * 0.5 * (1 + math.Erf(float64(x)/math.Sqrt2)))
+	}
+}
+
+func backwardGelu[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i, x := range m.W {
+		// grad is Phi(x) + x * phi(x)
+		xf := float64(x)
+		cdf := 0.5 * (1 + math.Erf(xf/math.Sqrt2))
+		pdf := math.Exp(-0.5*xf*xf) / math.Sqrt(2*math.Pi)
+		m.DW[i] += T(cdf+xf*pdf) * out.DW[i]
+	}
+}
+
+/*
+LeakyRelu is a relu that lets `slope` of a negative input through.
+*/
+func (g *GraphOf[T]) LeakyRelu(m *MatOf[T], slope T) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: OpLeakyRelu, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m), Scalar: slope})
+}
+
+func forwardLeakyRelu[T Float](op *TapeOp[T]) {
+	slope := op.Scalar
+	for i, x := range op.Inputs[0].W {
+		if x > 0 {
+			op.Output.W[i] = x
+		} else {
+			op.Output.W[i] = slope * x
+		}
+	}
+}
+
+func backwardLeakyRelu[T Float](op *TapeOp[T]) {
+	m, out, slope := op.Inputs[0], op.Output, op.Scalar
+	for i, x := range m.W {
+		if x > 0 {
+			m.DW[i] += out.DW[i]
+		} else {
+			m.DW[i] += slope * out.DW[i]
+		}
+	}
+}
+
+/*
+PRelu is a leaky relu whose negative slope is learned, one per row: alpha is
+m.RowCount x 1 and shared by every column of a batch.
+*/
+func (g *GraphOf[T]) PRelu(m *MatOf[T], alpha *MatOf[T]) *MatOf[T] {
+	Assert(len(alpha.W) == m.RowCount, "PRelu needs one alpha per row")
+	return g.binary(OpPRelu, m, alpha)
+}
+
+func forwardPRelu[T Float](op *TapeOp[T]) {
+	m, alpha, out := op.Inputs[0], op.Inputs[1], op.Output
+	b := m.ColumnCount
+	for i, x := range m.W {
+		if x > 0 {
+			out.W[i] = x
+		} else {
+			out.W[i] = alpha.W[i/b] * x
+		}
+	}
+}
+
+func backwardPRelu[T Float](op *TapeOp[T]) {
+	m, alpha, out := op.Inputs[0], op.Inputs[1], op.Output
+	b := m.ColumnCount
+	for i, x := range m.W {
+		if x > 0 {
+			m.DW[i] += out.DW[i]
+		} else {
+			m.DW[i] += alpha.W[i/b] * out.DW[i]
+			alpha.DW[i/b] += x * out.DW[i]
+		}
+	}
+}
+
+/*
+Elu is x for positive x and alpha * (e^x - 1) below zero.
+*/
+func (g *GraphOf[T]) Elu(m *MatOf[T], alpha T) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: OpElu, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m), Scalar: alpha})
+}
+
+func forwardElu[T Float](op *TapeOp[T]) {
+	alpha := op.Scalar
+	for i, x := range op.Inputs[0].W {
+		if x > 0 {
+			op.Output.W[i] = x
+		} else {
+			op.Output.W[i] = alpha * T(math.Expm1(float64(x)))
+		}
+	}
+}
+
+func backwardElu[T Float](op *TapeOp[T]) {
+	m, out, alpha := op.Inputs[0], op.Output, op.Scalar
+	for i, x := range m.W {
+		if x > 0 {
+			m.DW[i] += out.DW[i]
+		} else {
+			// grad below zero is alpha * e^x, which is out + alpha
+			m.DW[i] += (out.W[i] + alpha) * out.DW[i]
+		}
+	}
+}
+
+/*
+Softplus is log(1 + e^x), a smooth relu.
+*/
+func (g *GraphOf[T]) Softplus(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpSoftplus, m)
+}
+
+func forwardSoftplus[T Float](op *TapeOp[T]) {
+	for i, x := range op.Inputs[0].W {
+		xf := float64(x)
+		// log1p(e^x) overflows for big x, where it is x anyway
+		op.Output.W[i] = T(math.Max(xf, 0) + math.Log1p(math.Exp(-math.Abs(xf))))
+	}
+}
+
+func backwardSoftplus[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i, x := range m.W {
+		// grad is sigmoid(x)
+		m.DW[i] += T(1/(1+math.Exp(-float64(x)))) * out.DW[i]
+	}
+}
+
+/*
+Swish is x * sigmoid(x), also known as SiLU.
+*/
+func (g *GraphOf[T]) Swish(m *MatOf[T]) *MatOf[T] {
+	sig := g.floats(len(m.W)) // kept for backprop
+	return g.run(TapeOp[T]{Kind: OpSwish, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m), Saved: g.keepSaved(sig)})
+}
+
+func forwardSwish[T Float](op *TapeOp[T]) {
+	sig := op.Saved[0]
+	for i, x := range op.Inputs[0].W {
+		sig[i] = T(1 / (1 + math.Exp(-float64(x))))
+		op.Output.W[i] = x * sig[i]
+	}
+}
+
+func backwardSwish[T Float](op *TapeOp[T]) {
+	m, out, sig := op.Inputs[0], op.Output, op.Saved[0]
+	for i := range m.W {
+		// grad is s + x * s * (1 - s), or s + out * (1 - s)
+		m.DW[i] += (sig[i] + out.W[i]*(1-sig[i])) * out.DW[i]
+	}
+}
+
+/*
+HardSigmoid is the piecewise linear sigmoid clamp(x/6 + 1/2, 0, 1). It needs
+no exp, so it is much cheaper than Sigmoid.
+*/
+func (g *GraphOf[T]) HardSigmoid(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpHardSigmoid, m)
+}
+
+func forwardHardSigmoid[T Float](op *TapeOp[T]) {
+	for i, x := range op.Inputs[0].W {
+		op.Output.W[i] = min(max(x/6+0.5, 0), 1)
+	}
+}
+
+func backwardHardSigmoid[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i, x := range m.W {
+		if x > -3 && x < 3 {
+			m.DW[i] += out.DW[i] / 6
+		}
+	}
+}
+
+/*
+HardTanh is the piecewise linear tanh clamp(x, -1, 1). It needs no exp, so it
+is much cheaper than Tanh.
+*/
+func (g *GraphOf[T]) HardTanh(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpHardTanh, m)
+}
+
+func forwardHardTanh[T Float](op *TapeOp[T]) {
+	for i, x := range op.Inputs[0].W {
+		op.Output.W[i] = min(max(x, -1), 1)
+	}
+}
+
+func backwardHardTanh[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i, x := range m.W {
+		if x > -1 && x < 1 {
+			m.DW[i] += out.DW[i]
+		}
+	}
+}
+
+/*
+Activation is an elementwise Graph op, picked by name with ActivationOf.
+*/
+type Activation[T Float] func(g *GraphOf[T], m *MatOf[T]) *MatOf[T]
+
+/*
+activations are the named activations. The parameterized ones get their
+usual defaults; PRelu has a learned parameter, so it is left out.
+*/
+func activations[T Float]() map[string]Activation[T] {
+	return map[string]Activation[T]{
+		"tanh":        (*GraphOf[T]).Tanh,
+		"sigmoid":     (*GraphOf[T]).Sigmoid,
+		"relu":        (*GraphOf[T]).Relu,
+		"gelu":        (*GraphOf[T]).Gelu,
+		"leakyrelu":   func(g *GraphOf[T], m *MatOf[T]) *MatOf[T] { return g.LeakyRelu(m, 0.01) },
+		"elu":         func(g *GraphOf[T], m *MatOf[T]) *MatOf[T] { return g.Elu(m, 1) },
+		"softplus":    (*GraphOf[T]).Softplus,
+		"swish":       (*GraphOf[T]).Swish,
+		"hardsigmoid": (*GraphOf[T]).HardSigmoid,
+		"hardtanh":    (*GraphOf[T]).HardTanh,
+	}
+}
+
+/*
+ActivationNames lists the names ActivationOf knows, sorted.
+*/
+func ActivationNames() []string {
+	names := make([]string, 0)
+	for name := range activations[float32]() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+ActivationOf looks up an activation by name, like "tanh" or "hardtanh".
+*/
+func ActivationOf[T Float](name string) (Activation[T], error) {
+	f, ok := activations[T]()[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown activation %q, want one of %v", name, ActivationNames())
+	}
+	return f, nil
+}
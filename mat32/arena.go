@@ -0,0 +1,215 @@
+package mat32
+
+import "sync"
+
+/*
+ArenaOf recycles the Mats and scratch buffers that graph ops allocate for every
+timestep of every tick. A Graph with an Arena draws from it, and hands
+everything back in one go when ResetBackprop starts the next graph, so a
+training tick settles into doing next to no heap allocation.
+
+The zero value is ready to use. It is safe for concurrent use.
+*/
+type ArenaOf[T Float] struct {
+	mux    sync.Mutex
+	free   map[int][]*MatOf[T] // free Mats, by element count
+	used   []*MatOf[T]
+	floats pool[T]
+	mats   pool[*MatOf[T]] // the Inputs and Extra of ops
+	ints   pool[int]
+	saved  pool[[]T]
+}
+
+/*
+pool recycles slices of E, by length.
+*/
+type pool[E any] struct {
+	free map[int][][]E
+	used [][]E
+}
+
+/*
+get returns a slice of n, zeroed, reusing a released one when there is one.
+*/
+func (p *pool[E]) get(n int) []E {
+	if n == 0 {
+		return nil
+	}
+	var s []E
+	if list := p.free[n]; len(list) > 0 {
+		s = list[len(list)-1]
+		p.free[n] = list[:len(list)-1]
+		clear(s)
+	} else {
+		s = make([]E, n)
+	}
+	p.used = append(p.used, s)
+	return s
+}
+
+/*
+release takes back every slice get has handed out.
+*/
+func (p *pool[E]) release() {
+	if p.free == nil {
+		p.free = make(map[int][][]E)
+	}
+	for i, s := range p.used {
+		p.free[len(s)] = append(p.free[len(s)], s)
+		p.used[i] = nil
+	}
+	p.used = p.used[:0]
+}
+
+/*
+Arena is the float32 arena that training uses.
+*/
+type Arena = ArenaOf[float32]
+
+/*
+Arena64 is a float64 arena.
+*/
+type Arena64 = ArenaOf[float64]
+
+/*
+NewMat returns a zeroed n x d Mat, reusing a released one of the same size
+when there is one.
+*/
+func (a *ArenaOf[T]) NewMat(n int, d int) *MatOf[T] {
+	size := n * d
+	a.mux.Lock()
+	var m *MatOf[T]
+	if list := a.free[size]; len(list) > 0 {
+		m = list[len(list)-1]
+		a.free[size] = list[:len(list)-1]
+	}
+	if m == nil {
+		m = NewMatOf[T](n, d)
+	}
+	a.used = append(a.used, m)
+	a.mux.Unlock()
+
+	m.RowCount = n
+	m.ColumnCount = d
+	clear(m.W)
+	clear(m.DW)
+	return m
+}
+
+/*
+Floats returns a zeroed scratch buffer of n values, such as a dropout mask.
+*/
+func (a *ArenaOf[T]) Floats(n int) []T {
+	a.mux.Lock()
+	f := a.floats.get(n)
+	a.mux.Unlock()
+	return f
+}
+
+/*
+Release takes back everything the Arena has handed out. Nothing handed out
+before the call may be used after it.
+*/
+func (a *ArenaOf[T]) Release() {
+	a.mux.Lock()
+	if a.free == nil {
+		a.free = make(map[int][]*MatOf[T])
+	}
+	for i, m := range a.used {
+		a.free[len(m.W)] = append(a.free[len(m.W)], m)
+		a.used[i] = nil
+	}
+	a.used = a.used[:0]
+	a.floats.release()
+	a.mats.release()
+	a.ints.release()
+	a.saved.release()
+	a.mux.Unlock()
+}
+
+/*
+handedOut is the set of Mats the Arena has handed out since the last
+Release.
+*/
+func (a *ArenaOf[T]) handedOut() map[*MatOf[T]]bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	mats := make(map[*MatOf[T]]bool, len(a.used))
+	for _, m := range a.used {
+		mats[m] = true
+	}
+	return mats
+}
+
+/*
+NewMat returns a zeroed n x d Mat for a graph op, from the Graph's Arena if
+it has one.
+*/
+func (g *GraphOf[T]) NewMat(n int, d int) *MatOf[T] {
+	if g.Arena != nil {
+		return g.Arena.NewMat(n, d)
+	}
+	return NewMatOf[T](n, d)
+}
+
+/*
+floats returns a zeroed scratch buffer for a graph op to keep until Backward.
+*/
+func (g *GraphOf[T]) floats(n int) []T {
+	if g.Arena != nil {
+		return g.Arena.Floats(n)
+	}
+	return make([]T, n)
+}
+
+/*
+Mats returns a list of n nil Mats to hold on to op outputs with, like the
+states of each layer of a network. It is from the Arena when there is one, so
+it goes back with the graph's Mats.
+*/
+func (g *GraphOf[T]) Mats(n int) []*MatOf[T] {
+	if g.Arena == nil {
+		return make([]*MatOf[T], n)
+	}
+	g.Arena.mux.Lock()
+	defer g.Arena.mux.Unlock()
+	return g.Arena.mats.get(n)
+}
+
+/*
+keepMats copies ms into a list an op can keep until the graph is reset, from
+the Arena when there is one. keepInts and keepSaved are the same for an op's
+Ints and Saved.
+*/
+func (g *GraphOf[T]) keepMats(ms ...*MatOf[T]) []*MatOf[T] {
+	if g.Arena == nil {
+		return append([]*MatOf[T](nil), ms...)
+	}
+	g.Arena.mux.Lock()
+	kept := g.Arena.mats.get(len(ms))
+	g.Arena.mux.Unlock()
+	copy(kept, ms)
+	return kept
+}
+
+func (g *GraphOf[T]) keepInts(is ...int) []int {
+	if g.Arena == nil {
+		return append([]int(nil), is...)
+	}
+	g.Arena.mux.Lock()
+	kept := g.Arena.ints.get(len(is))
+	g.Arena.mux.Unlock()
+	copy(kept, is)
+	return kept
+}
+
+func (g *GraphOf[T]) keepSaved(bufs ...[]T) [][]T {
+	if g.Arena == nil {
+		return append([][]T(nil), bufs...)
+	}
+	g.Arena.mux.Lock()
+	kept := g.Arena.saved.get(len(bufs))
+	g.Arena.mux.Unlock()
+	copy(kept, bufs)
+	return kept
+}
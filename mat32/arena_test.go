@@ -0,0 +1,52 @@
+package mat32
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+TestArenaAllocations runs the graph of a small LSTM over a sequence again
+and again on one Graph with an Arena. Once warmed up, the ops and Backward
+should allocate nothing but the goroutines Backward starts.
+*/
+func TestArenaAllocations(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	const nx, nh, steps = 8, 20, 40
+	embed := InitMatOf[float32](r, 10, nx, Uniform{Scale: 0.08})
+	W := InitMatOf[float32](r, 4*nh, nx+nh, Uniform{Scale: 0.08})
+	b := NewMat(4*nh, 1)
+	Whd := InitMatOf[float32](r, 10, nh, Uniform{Scale: 0.08})
+	g := &Graph{Arena: &Arena{}}
+	ixs, targets := make([]int, 2), make([]int, 2)
+	tick := func() {
+		g.ResetBackprop(true)
+		h, c := g.NewMat(nh, 2), g.NewMat(nh, 2)
+		for i := 0; i < steps; i++ {
+			ixs[0], ixs[1] = i%10, (i+3)%10
+			targets[0], targets[1] = (i+1)%10, -1
+			h, c = g.LSTMCell(W, b, g.RowsPluck(embed, ixs), h, c)
+			loss := g.SoftmaxCrossEntropy(g.Add(g.Mul(Whd, h), g.Tanh(g.Mul(Whd, c))), targets)
+			loss.DW[0] = 1
+		}
+		g.Backward()
+		g.ClearTouchedRows()
+	}
+	for _, threads := range []int{1, 4} {
+		withThreads(threads, func() {
+			// the arena's free lists settle after the first couple
+			for i := 0; i < 3; i++ {
+				tick()
+			}
+			allocs := testing.AllocsPerRun(10, tick)
+			t.Logf("threads=%d: %.0f allocations a tick", threads, allocs)
+			want := 0
+			if threads > 1 {
+				want = threads // starting the goroutines
+			}
+			if allocs > float64(want) {
+				t.Errorf("threads=%d: %.0f allocations a tick, want at most %d", threads, allocs, want)
+			}
+		})
+	}
+}
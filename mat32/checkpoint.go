@@ -0,0 +1,147 @@
+package mat32
+
+import "math/rand/v2"
+
+/*
+Checkpoint builds a segment of the graph without keeping it: build makes the
+segment's ops on g and returns its outputs, but only copies of the outputs
+stay on the tape, as a single op. Backward builds the segment again, from
+the same inputs and the same random numbers, to backprop through it. So a
+long unrolled sequence cut into checkpointed segments holds on to a
+segment's boundary states instead of every gate of every step, for the cost
+of a second forward pass.
+
+build may read any Mat made outside the segment, like parameters and the
+outputs of the segment before, and they get their gradients as usual. Seed
+the gradient of the outputs Checkpoint returns, not of the ones build
+returns, which are gone as soon as it has run. Nothing else may build ops on
+g while build runs, and checkpoints do not nest.
+
+Without backprop, Checkpoint just runs build.
+*/
+func (g *GraphOf[T]) Checkpoint(build func() []*MatOf[T]) []*MatOf[T] {
+	if !g.NeedsBackprop {
+		return build()
+	}
+	Assert(!g.segmenting, "checkpoints do not nest")
+	s := &segment[T]{g: g, build: build, graphRand: g.Rand}
+
+	op := TapeOp[T]{Kind: OpCheckpoint, segment: s}
+	var outs []*MatOf[T]
+	s.run(func(segOuts []*MatOf[T], tape []TapeOp[T], scratch *ArenaOf[T]) {
+		Assert(len(segOuts) > 0, "a checkpoint segment needs outputs")
+		// the segment's inputs are what its ops read that none of them made
+		internal := scratch.handedOut()
+		for i := range tape {
+			internal[tape[i].Output] = true
+			for _, m := range tape[i].Extra {
+				internal[m] = true
+			}
+		}
+		for i := range tape {
+			for _, m := range tape[i].Inputs {
+				if !internal[m] {
+					internal[m] = true
+					op.Inputs = append(op.Inputs, m)
+				}
+			}
+		}
+		outs = make([]*MatOf[T], len(segOuts))
+		for i, m := range segOuts {
+			outs[i] = g.NewMat(m.RowCount, m.ColumnCount)
+			copy(outs[i].W, m.W)
+		}
+	})
+	s.graphRand = nil
+	op.Output = outs[0]
+	op.Extra = outs[1:]
+	g.record(op)
+	return outs
+}
+
+/*
+segment is what an OpCheckpoint needs to build its segment again.
+*/
+type segment[T Float] struct {
+	g         *GraphOf[T]
+	build     func() []*MatOf[T]
+	seed      [2]uint64
+	hasRand   bool
+	graphRand *rand.Rand // the graph's Rand, until the first build is done
+	pcg       *rand.PCG  // the segment's stream, once it has a seed
+}
+
+/*
+Uint64 makes a segment the random source of its builds. Only once the
+segment asks for a random number does it draw a seed for its own stream from
+the graph's Rand, so building it again draws the same, and a segment that
+draws nothing leaves the graph's Rand as it was.
+*/
+func (s *segment[T]) Uint64() uint64 {
+	if s.pcg == nil {
+		s.seed = [2]uint64{s.graphRand.Uint64(), s.graphRand.Uint64()}
+		s.hasRand = true
+		s.pcg = rand.NewPCG(s.seed[0], s.seed[1])
+	}
+	return s.pcg.Uint64()
+}
+
+/*
+run builds the segment on its own tape, from the graph's scratch arena and
+with its own Rand, and hands the outputs and tape to use. The graph is back
+the way it was by then, and the segment is thrown away once use returns.
+*/
+func (s *segment[T]) run(use func(outs []*MatOf[T], tape []TapeOp[T], scratch *ArenaOf[T])) {
+	g := s.g
+	g.segMux.Lock()
+	defer g.segMux.Unlock()
+	if g.scratch == nil {
+		g.scratch = &ArenaOf[T]{}
+	}
+
+	tape, arena, rng, trace, needsBackprop := g.Tape, g.Arena, g.Rand, g.Trace, g.NeedsBackprop
+	g.Tape, g.Arena, g.Rand, g.Trace, g.NeedsBackprop = g.segTape[:0], g.scratch, nil, nil, true
+	if s.hasRand || s.graphRand != nil {
+		s.pcg = nil
+		if s.hasRand {
+			s.pcg = rand.NewPCG(s.seed[0], s.seed[1])
+		}
+		g.Rand = rand.New(s)
+	}
+	g.segmenting = true
+	outs := s.build()
+	g.segmenting = false
+	segTape := g.Tape
+	g.Tape, g.Arena, g.Rand, g.Trace, g.NeedsBackprop = tape, arena, rng, trace, needsBackprop
+
+	use(outs, segTape, g.scratch)
+	clear(segTape)
+	g.segTape = segTape[:0]
+	g.scratch.Release()
+}
+
+func (op *TapeOp[T]) outputs() []*MatOf[T] {
+	return append([]*MatOf[T]{op.Output}, op.Extra...)
+}
+
+func forwardCheckpoint[T Float](op *TapeOp[T]) {
+	outs := op.outputs()
+	op.segment.run(func(segOuts []*MatOf[T], _ []TapeOp[T], _ *ArenaOf[T]) {
+		for i, m := range segOuts {
+			copy(outs[i].W, m.W)
+		}
+	})
+}
+
+func backwardCheckpoint[T Float](op *TapeOp[T]) {
+	outs := op.outputs()
+	op.segment.run(func(segOuts []*MatOf[T], tape []TapeOp[T], _ *ArenaOf[T]) {
+		for i, m := range segOuts {
+			// adding, not copying, in case build returned one of its inputs
+			for j := range m.DW {
+				m.DW[j] += outs[i].DW[j]
+			}
+		}
+		op.segment.g.segBackward.backward(tape)
+	})
+}
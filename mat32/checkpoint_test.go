@@ -0,0 +1,47 @@
+package mat32
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+TestCheckpointRand checks a checkpoint only takes from the graph's Rand when
+its segment draws random numbers, and that building it again for backprop
+draws the same ones.
+*/
+func TestCheckpointRand(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	x := RandMat(r, 50, 1, 1)
+
+	g := &Graph{NeedsBackprop: true, Rand: rand.New(rand.NewPCG(3, 4))}
+	g.Checkpoint(func() []*Mat {
+		return []*Mat{g.Tanh(x)}
+	})
+	want := rand.New(rand.NewPCG(3, 4)).Uint64()
+	if got := g.Rand.Uint64(); got != want {
+		t.Errorf("a segment without dropout took from the graph's Rand")
+	}
+
+	outs := g.Checkpoint(func() []*Mat {
+		return []*Mat{g.Dropout(x, 0.5)}
+	})
+	for i := range outs[0].DW {
+		outs[0].DW[i] = 1
+	}
+	clear(x.DW)
+	g.Backward()
+	dropped := 0
+	for i, v := range outs[0].W {
+		// a dropped value has no gradient, a kept one does
+		if (v == 0) != (x.DW[i] == 0) {
+			t.Fatalf("element %d is %v going forward but got a gradient of %v", i, v, x.DW[i])
+		}
+		if v == 0 {
+			dropped++
+		}
+	}
+	if dropped == 0 || dropped == len(x.W) {
+		t.Errorf("dropout dropped %d of %d", dropped, len(x.W))
+	}
+}
@@ -0,0 +1,157 @@
+package mat32
+
+/*
+ConcatRows stacks matrices with the same number of columns on top of each
+other, like [x; h].
+*/
+func (g *GraphOf[T]) ConcatRows(ms ...*MatOf[T]) *MatOf[T] {
+	Assert(len(ms) > 0, "ConcatRows needs something to concatenate")
+	d := ms[0].ColumnCount
+	n := 0
+	for _, m := range ms {
+		Assert(m.ColumnCount == d, "ConcatRows column counts differ")
+		n += m.RowCount
+	}
+	return g.run(TapeOp[T]{Kind: OpConcatRows, Output: g.NewMat(n, d), Inputs: g.keepMats(ms...)})
+}
+
+func forwardConcatRows[T Float](op *TapeOp[T]) {
+	offset := 0
+	for _, m := range op.Inputs {
+		copy(op.Output.W[offset:], m.W)
+		offset += len(m.W)
+	}
+}
+
+func backwardConcatRows[T Float](op *TapeOp[T]) {
+	offset := 0
+	for _, m := range op.Inputs {
+		for i := range m.DW {
+			m.DW[i] += op.Output.DW[offset+i]
+		}
+		offset += len(m.DW)
+	}
+}
+
+/*
+ConcatColumns puts matrices with the same number of rows side by side.
+*/
+func (g *GraphOf[T]) ConcatColumns(ms ...*MatOf[T]) *MatOf[T] {
+	Assert(len(ms) > 0, "ConcatColumns needs something to concatenate")
+	n := ms[0].RowCount
+	d := 0
+	for _, m := range ms {
+		Assert(m.RowCount == n, "ConcatColumns row counts differ")
+		d += m.ColumnCount
+	}
+	return g.run(TapeOp[T]{Kind: OpConcatColumns, Output: g.NewMat(n, d), Inputs: g.keepMats(ms...)})
+}
+
+func forwardConcatColumns[T Float](op *TapeOp[T]) {
+	out := op.Output
+	n, d := out.RowCount, out.ColumnCount
+	offset := 0
+	for _, m := range op.Inputs {
+		for r := 0; r < n; r++ {
+			copy(out.W[r*d+offset:], m.W[r*m.ColumnCount:(r+1)*m.ColumnCount])
+		}
+		offset += m.ColumnCount
+	}
+}
+
+func backwardConcatColumns[T Float](op *TapeOp[T]) {
+	out := op.Output
+	n, d := out.RowCount, out.ColumnCount
+	offset := 0
+	for _, m := range op.Inputs {
+		md := m.ColumnCount
+		for r := 0; r < n; r++ {
+			for j := 0; j < md; j++ {
+				m.DW[r*md+j] += out.DW[r*d+offset+j]
+			}
+		}
+		offset += md
+	}
+}
+
+/*
+SliceRows returns rows [from, to) of m.
+*/
+func (g *GraphOf[T]) SliceRows(m *MatOf[T], from int, to int) *MatOf[T] {
+	Assert(from >= 0 && from < to && to <= m.RowCount, "SliceRows invalid range")
+	return g.run(TapeOp[T]{Kind: OpSliceRows, Output: g.NewMat(to-from, m.ColumnCount), Inputs: g.keepMats(m), Ints: g.keepInts(from, to)})
+}
+
+func forwardSliceRows[T Float](op *TapeOp[T]) {
+	m, from, to := op.Inputs[0], op.Ints[0], op.Ints[1]
+	d := m.ColumnCount
+	copy(op.Output.W, m.W[from*d:to*d])
+}
+
+func backwardSliceRows[T Float](op *TapeOp[T]) {
+	m, from, to := op.Inputs[0], op.Ints[0], op.Ints[1]
+	d := m.ColumnCount
+	dw := m.DW[from*d : to*d]
+	for i := range dw {
+		dw[i] += op.Output.DW[i]
+	}
+}
+
+/*
+SliceColumns returns columns [from, to) of m.
+*/
+func (g *GraphOf[T]) SliceColumns(m *MatOf[T], from int, to int) *MatOf[T] {
+	Assert(from >= 0 && from < to && to <= m.ColumnCount, "SliceColumns invalid range")
+	return g.run(TapeOp[T]{Kind: OpSliceColumns, Output: g.NewMat(m.RowCount, to-from), Inputs: g.keepMats(m), Ints: g.keepInts(from, to)})
+}
+
+func forwardSliceColumns[T Float](op *TapeOp[T]) {
+	m, out, from, to := op.Inputs[0], op.Output, op.Ints[0], op.Ints[1]
+	d := m.ColumnCount
+	w := to - from
+	for r := 0; r < m.RowCount; r++ {
+		copy(out.W[r*w:(r+1)*w], m.W[r*d+from:r*d+to])
+	}
+}
+
+func backwardSliceColumns[T Float](op *TapeOp[T]) {
+	m, out, from, to := op.Inputs[0], op.Output, op.Ints[0], op.Ints[1]
+	d := m.ColumnCount
+	w := to - from
+	for r := 0; r < m.RowCount; r++ {
+		for j := 0; j < w; j++ {
+			m.DW[r*d+from+j] += out.DW[r*w+j]
+		}
+	}
+}
+
+/*
+SplitRows cuts m into consecutive blocks of rows with the given sizes, which
+must add up to m.RowCount - for example the four gate blocks of a stacked
+gate pre-activation.
+*/
+func (g *GraphOf[T]) SplitRows(m *MatOf[T], sizes ...int) []*MatOf[T] {
+	parts := make([]*MatOf[T], len(sizes))
+	from := 0
+	for i, size := range sizes {
+		parts[i] = g.SliceRows(m, from, from+size)
+		from += size
+	}
+	Assert(from == m.RowCount, "SplitRows sizes must add up to the row count")
+	return parts
+}
+
+/*
+SplitColumns cuts m into consecutive blocks of columns with the given sizes,
+which must add up to m.ColumnCount.
+*/
+func (g *GraphOf[T]) SplitColumns(m *MatOf[T], sizes ...int) []*MatOf[T] {
+	parts := make([]*MatOf[T], len(sizes))
+	from := 0
+	for i, size := range sizes {
+		parts[i] = g.SliceColumns(m, from, from+size)
+		from += size
+	}
+	Assert(from == m.ColumnCount, "SplitColumns sizes must add up to the column count")
+	return parts
+}
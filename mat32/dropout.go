@@ -0,0 +1,40 @@
+package mat32
+
+/*
+Dropout zeroes each element of m with probability `rate` while training,
+scaling the survivors by 1/(1-rate) so the expected activation is unchanged
+("inverted" dropout). When the graph is not training (NeedsBackprop is off)
+it is the identity and returns m itself.
+
+The mask is drawn from g.Rand when the op is built, and Replay reuses it.
+*/
+func (g *GraphOf[T]) Dropout(m *MatOf[T], rate T) *MatOf[T] {
+	Assert(rate >= 0 && rate < 1, "Dropout rate must be in [0, 1)")
+	if !g.NeedsBackprop || rate == 0 {
+		return m
+	}
+	Assert(g.Rand != nil, "Dropout needs the graph to have a Rand")
+
+	mask := g.floats(len(m.W))
+	keep := 1 / (1 - rate)
+	for i := range mask {
+		if RandfOf[T](g.Rand, 0, 1) >= rate {
+			mask[i] = keep
+		}
+	}
+	return g.run(TapeOp[T]{Kind: OpDropout, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m), Saved: g.keepSaved(mask)})
+}
+
+func forwardDropout[T Float](op *TapeOp[T]) {
+	m, out, mask := op.Inputs[0], op.Output, op.Saved[0]
+	for i := range mask {
+		out.W[i] = m.W[i] * mask[i]
+	}
+}
+
+func backwardDropout[T Float](op *TapeOp[T]) {
+	m, out, mask := op.Inputs[0], op.Output, op.Saved[0]
+	for i := range mask {
+		m.DW[i] += mask[i] * out.DW[i]
+	}
+}
@@ -0,0 +1,318 @@
+package mat32
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+/*
+GradCheckDelta is the default finite difference step used by the gradient checks.
+It is large-ish for a float32 forward pass; a float64 one can go much smaller.
+*/
+const GradCheckDelta = 1e-2
+
+/*
+GradCheckResult holds the outcome of a numerical gradient check.
+*/
+type GradCheckResult struct {
+	Name        string
+	Checked     int     // how many elements were perturbed
+	MaxRelError float64 // worst relative error between analytic and numerical gradient
+	MaxAbsError float64 // worst absolute error, useful when gradients are tiny
+}
+
+func (r GradCheckResult) String() string {
+	return fmt.Sprintf("%-20s checked=%-6d maxRelError=%.3e maxAbsError=%.3e", r.Name, r.Checked, r.MaxRelError, r.MaxAbsError)
+}
+
+/*
+RelError is the symmetric relative error between an analytic and a numerical
+gradient. Pairs that are both ~zero count as a match.
+*/
+func RelError(analytic float64, numerical float64) float64 {
+	diff := math.Abs(analytic - numerical)
+	scale := math.Max(math.Abs(analytic), math.Abs(numerical))
+	if scale < 1e-7 {
+		return 0
+	}
+	return diff / scale
+}
+
+/*
+CheckGradient compares the gradients produced by backward against central
+finite differences of forward, for every element of every input.
+
+forward must compute the whole graph again - build it anew, or Replay it -
+and return the scalar loss, having seeded the DW of its output so that
+backward propagates d(loss)/d(input) into inputs[...].DW. backward is only called once, for the analytic pass.
+
+maxChecks limits how many elements are perturbed per input (spread evenly
+over the input); zero or less means every element.
+*/
+func CheckGradient[T Float](name string, inputs []*MatOf[T], forward func() float64, backward func(), delta T, maxChecks int) GradCheckResult {
+	result := GradCheckResult{Name: name}
+
+	// analytic pass
+	for _, m := range inputs {
+		for i := range m.DW {
+			m.DW[i] = 0
+		}
+	}
+	forward()
+	backward()
+	analytic := make([][]T, len(inputs))
+	for mi, m := range inputs {
+		analytic[mi] = make([]T, len(m.DW))
+		copy(analytic[mi], m.DW)
+	}
+
+	// numerical pass
+	for mi, m := range inputs {
+		n := len(m.W)
+		stride := 1
+		if maxChecks > 0 && n > maxChecks {
+			stride = n / maxChecks
+		}
+		for i := 0; i < n; i += stride {
+			orig := m.W[i]
+			m.W[i] = orig + delta
+			lossPlus := forward()
+			m.W[i] = orig - delta
+			lossMinus := forward()
+			m.W[i] = orig
+
+			// the actual step may differ from delta after float32 rounding
+			step := float64(orig+delta) - float64(orig-delta)
+			numerical := (lossPlus - lossMinus) / step
+			a := float64(analytic[mi][i])
+
+			result.Checked++
+			result.MaxRelError = math.Max(result.MaxRelError, RelError(a, numerical))
+			result.MaxAbsError = math.Max(result.MaxAbsError, math.Abs(a-numerical))
+		}
+	}
+
+	// leave the inputs the way we found them
+	for _, m := range inputs {
+		for i := range m.DW {
+			m.DW[i] = 0
+		}
+	}
+	return result
+}
+
+/*
+CheckOp gradient checks a single Graph operation. op is run once, and its
+tape is replayed for every perturbed input, so Replay gets checked along
+with the backprop. The output is reduced to a scalar loss by a fixed random
+projection, drawn from r, so that every output element contributes to the
+gradient.
+*/
+func CheckOp[T Float](r *rand.Rand, name string, inputs []*MatOf[T], op func(g *GraphOf[T]) *MatOf[T], delta T) GradCheckResult {
+	g := &GraphOf[T]{}
+	var out *MatOf[T]
+	var projection []T
+	forward := func() float64 {
+		if out == nil {
+			g.ResetBackprop(true)
+			out = op(g)
+			projection = make([]T, len(out.W))
+			for i := range projection {
+				projection[i] = RandfOf[T](r, -1, 1)
+			}
+			copy(out.DW, projection)
+		} else {
+			err := g.Replay()
+			Assert(err == nil, fmt.Sprint(err))
+		}
+		var loss float64
+		for i := range out.W {
+			loss += float64(projection[i]) * float64(out.W[i])
+		}
+		return loss
+	}
+	return CheckGradient(name, inputs, forward, g.Backward, delta, 0)
+}
+
+/*
+CheckOps gradient checks every Graph operation on small random inputs and
+returns the per-op results. The precision of the check follows delta, and the
+inputs are drawn from r.
+*/
+func CheckOps[T Float](r *rand.Rand, delta T) []GradCheckResult {
+	var results []GradCheckResult
+
+	embed := RandMatOf[T](r, 5, 3, 1)
+	results = append(results, CheckOp(r, "RowPluck", []*MatOf[T]{embed}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.RowPluck(embed, 2)
+	}, delta))
+
+	results = append(results, CheckOp(r, "RowsPluck", []*MatOf[T]{embed}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.RowsPluck(embed, []int{2, 0, 2, 4})
+	}, delta))
+
+	a := RandMatOf[T](r, 4, 3, 1)
+	results = append(results, CheckOp(r, "Tanh", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Tanh(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Sigmoid", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Sigmoid(a)
+	}, delta))
+
+	// keep relu inputs away from the kink at zero
+	x := RandMatOf[T](r, 4, 3, 1)
+	for i := range x.W {
+		if x.W[i] > -0.1 && x.W[i] < 0.1 {
+			x.W[i] += 0.5
+		}
+	}
+	results = append(results, CheckOp(r, "Relu", []*MatOf[T]{x}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Relu(x)
+	}, delta))
+
+	results = append(results, CheckOp(r, "Gelu", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Gelu(a)
+	}, delta))
+	results = append(results, CheckOp(r, "LeakyRelu", []*MatOf[T]{x}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.LeakyRelu(x, 0.1)
+	}, delta))
+	alpha := RandMatOf[T](r, 4, 1, 1)
+	results = append(results, CheckOp(r, "PRelu", []*MatOf[T]{x, alpha}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.PRelu(x, alpha)
+	}, delta))
+	results = append(results, CheckOp(r, "Elu", []*MatOf[T]{x}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Elu(x, 0.7)
+	}, delta))
+	results = append(results, CheckOp(r, "Softplus", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Softplus(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Swish", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Swish(a)
+	}, delta))
+	// inside the linear part of the hard activations, away from their kinks
+	h := RandMatOf[T](r, 4, 3, 0.8)
+	results = append(results, CheckOp(r, "HardSigmoid", []*MatOf[T]{h}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.HardSigmoid(h)
+	}, delta))
+	results = append(results, CheckOp(r, "HardTanh", []*MatOf[T]{h}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.HardTanh(h)
+	}, delta))
+
+	m1 := RandMatOf[T](r, 4, 3, 1)
+	m2 := RandMatOf[T](r, 3, 2, 1)
+	results = append(results, CheckOp(r, "Mul", []*MatOf[T]{m1, m2}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Mul(m1, m2)
+	}, delta))
+	stacked := RandMatOf[T](r, 6, 5, 1)
+	results = append(results, CheckOp(r, "MulBlock", []*MatOf[T]{stacked, m2}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.MulBlock(stacked, 1, 5, 2, 5, m2)
+	}, delta))
+	v := RandMatOf[T](r, 3, 1, 1)
+	results = append(results, CheckOp(r, "MulBlockVector", []*MatOf[T]{stacked, v}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.MulBlock(stacked, 1, 5, 2, 5, v)
+	}, delta))
+
+	b := RandMatOf[T](r, 4, 3, 1)
+	results = append(results, CheckOp(r, "Add", []*MatOf[T]{a, b}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Add(a, b)
+	}, delta))
+	bias := RandMatOf[T](r, 4, 1, 1)
+	results = append(results, CheckOp(r, "AddBroadcast", []*MatOf[T]{a, bias}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Add(a, bias)
+	}, delta))
+	results = append(results, CheckOp(r, "Eltmul", []*MatOf[T]{a, b}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Eltmul(a, b)
+	}, delta))
+
+	results = append(results, CheckOp(r, "Sub", []*MatOf[T]{a, b}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Sub(a, b)
+	}, delta))
+	results = append(results, CheckOp(r, "Scale", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Scale(a, -1.5)
+	}, delta))
+	results = append(results, CheckOp(r, "Neg", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Neg(a)
+	}, delta))
+	// keep the divisor well away from zero
+	denom := RandMatOf[T](r, 4, 3, 0.5)
+	for i := range denom.W {
+		denom.W[i] += 2
+	}
+	results = append(results, CheckOp(r, "Div", []*MatOf[T]{a, denom}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Div(a, denom)
+	}, delta))
+	results = append(results, CheckOp(r, "Sum", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Sum(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Mean", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Mean(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Max", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Max(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Transpose", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Transpose(a)
+	}, delta))
+	results = append(results, CheckOp(r, "Reshape", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Reshape(a, 2, 6)
+	}, delta))
+	results = append(results, CheckOp(r, "Clone", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Clone(a)
+	}, delta))
+
+	lstmW := RandMatOf[T](r, 12, 5, 0.5)
+	lstmB := RandMatOf[T](r, 12, 1, 0.5)
+	lstmX := RandMatOf[T](r, 2, 2, 1)
+	lstmH := RandMatOf[T](r, 3, 2, 0.5)
+	lstmC := RandMatOf[T](r, 3, 2, 1)
+	results = append(results, CheckOp(r, "LSTMCell", []*MatOf[T]{lstmW, lstmB, lstmX, lstmH, lstmC}, func(g *GraphOf[T]) *MatOf[T] {
+		h, c := g.LSTMCell(lstmW, lstmB, lstmX, lstmH, lstmC)
+		return g.ConcatRows(h, c)
+	}, delta))
+	results = append(results, CheckOp(r, "SimplifiedLSTMCell", []*MatOf[T]{lstmW, lstmB, lstmX, lstmH, lstmC}, func(g *GraphOf[T]) *MatOf[T] {
+		h, c := g.SimplifiedLSTMCell(lstmW, lstmB, lstmX, lstmH, lstmC)
+		return g.ConcatRows(h, c)
+	}, delta))
+
+	gain := RandMatOf[T](r, 4, 1, 1)
+	results = append(results, CheckOp(r, "LayerNorm", []*MatOf[T]{a, gain, bias}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.LayerNorm(a, gain, bias)
+	}, delta))
+
+	c := RandMatOf[T](r, 2, 3, 1)
+	results = append(results, CheckOp(r, "ConcatRows", []*MatOf[T]{a, c}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.ConcatRows(a, c)
+	}, delta))
+	results = append(results, CheckOp(r, "ConcatColumns", []*MatOf[T]{a, bias}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.ConcatColumns(a, bias)
+	}, delta))
+	results = append(results, CheckOp(r, "SliceRows", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.SliceRows(a, 1, 3)
+	}, delta))
+	results = append(results, CheckOp(r, "SliceColumns", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.SliceColumns(a, 1, 3)
+	}, delta))
+	results = append(results, CheckOp(r, "SplitRows", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		parts := g.SplitRows(a, 1, 3)
+		// put the parts back in the other order so both get a gradient
+		return g.ConcatRows(parts[1], parts[0])
+	}, delta))
+	results = append(results, CheckOp(r, "SplitColumns", []*MatOf[T]{a}, func(g *GraphOf[T]) *MatOf[T] {
+		parts := g.SplitColumns(a, 2, 1)
+		return g.ConcatColumns(parts[1], parts[0])
+	}, delta))
+
+	logits := RandMatOf[T](r, 5, 3, 2)
+	results = append(results, CheckOp(r, "Softmax", []*MatOf[T]{logits}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.Softmax(logits)
+	}, delta))
+	results = append(results, CheckOp(r, "LogSoftmax", []*MatOf[T]{logits}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.LogSoftmax(logits)
+	}, delta))
+	results = append(results, CheckOp(r, "SoftmaxCrossEntropy", []*MatOf[T]{logits}, func(g *GraphOf[T]) *MatOf[T] {
+		return g.SoftmaxCrossEntropy(logits, []int{4, -1, 0})
+	}, delta))
+
+	return results
+}
@@ -0,0 +1,28 @@
+package mat32
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+maxOpRelError is how far the backprop of an op may be from central finite
+differences in float64.
+*/
+const maxOpRelError = 1e-5
+
+/*
+TestCheckOps gradient checks the backward pass of every Graph op.
+*/
+func TestCheckOps(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, result := range CheckOps[float64](r, 1e-5) {
+		t.Log(result)
+		if result.Checked == 0 {
+			t.Errorf("%s checked nothing", result.Name)
+		}
+		if !(result.MaxRelError <= maxOpRelError) {
+			t.Errorf("%s: relative error %.3e, over %.0e", result.Name, result.MaxRelError, maxOpRelError)
+		}
+	}
+}
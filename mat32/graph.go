@@ -2,266 +2,553 @@ package mat32
 
 import (
 	"math"
+	"math/rand/v2"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
-type backprop func()
-
 var concurrentThreads int = runtime.NumCPU()
 
 /*
-Graph is the neural network graph.
+GraphOf is the neural network graph, over matrices of T.
 */
-type Graph struct {
+type GraphOf[T Float] struct {
 	NeedsBackprop bool
-	Backprop      []backprop // holds backprop functions
-	bpMux         sync.Mutex // modifying backprop array
+	Tape          []TapeOp[T]                // the ops recorded for backprop, in order
+	bpMux         sync.Mutex                 // modifying the tape
+	touchedRows   map[*MatOf[T]]map[int]bool // rows given a gradient by a pluck
+	denseInputs   map[*MatOf[T]]bool         // Mats read by an op that is not a pluck
+	Arena         *ArenaOf[T]                // when set, op outputs are recycled between graphs
+	Rand          *rand.Rand                 // random source for ops like Dropout
+	Trace         *TraceOf[T]                // when set, ops are recorded for looking at
+	segMux        sync.Mutex                 // one checkpoint segment runs at a time
+	segmenting    bool                       // a checkpoint segment is being built
+	segTape       []TapeOp[T]                // the tape of the segment being built
+	scratch       *ArenaOf[T]                // where checkpoint segments are built
+	backward      backwardState[T]           // schedules Backward
+	backwardRun   bool                       // Backward has run on the tape
+	segBackward   backwardState[T]           // schedules a checkpoint segment's backward, inside Backward
 }
 
 /*
-ResetBackprop instantiates a new Graph
+Graph is the float32 graph that training uses.
+*/
+type Graph = GraphOf[float32]
+
+/*
+Graph64 is a float64 graph, for gradient checks and small research runs.
 */
-func (g *Graph) ResetBackprop(needsBackprop bool) {
+type Graph64 = GraphOf[float64]
+
+/*
+ResetBackprop instantiates a new Graph. With an Arena, every Mat the
+previous graph's ops handed out is taken back for reuse.
+*/
+func (g *GraphOf[T]) ResetBackprop(needsBackprop bool) {
 	g.NeedsBackprop = needsBackprop
-	g.Backprop = make([]backprop, 0)
+	clear(g.Tape)
+	g.Tape = g.Tape[:0]
+	g.backwardRun = false
+	if g.Arena != nil {
+		g.Arena.Release()
+	}
+	if g.Trace != nil {
+		g.Trace.Reset()
+	}
 }
 
 /*
-AddBackprop adds the backpropagation function `f` to the end of the Backrop list.
+AddBackprop adds the backpropagation function `f` to the end of the tape,
+for an op the graph has no kind for. `mats` must list every Mat whose DW `f`
+touches - usually the op output and its inputs. Backward orders the op by
+them, so it needs at least one.
 */
-func (g *Graph) AddBackprop(f func()) {
+func (g *GraphOf[T]) AddBackprop(f func(), mats ...*MatOf[T]) {
+	Assert(f != nil, "AddBackprop needs a function")
+	Assert(len(mats) > 0, "AddBackprop needs the Mats f touches")
+	g.record(TapeOp[T]{Kind: OpFunc, Inputs: g.keepMats(mats...), fn: f})
+}
+
+/*
+Branch returns an empty Graph for recording ops on another goroutine.
+Merge the branches back in a fixed order, so the order of the ops - and
+so the gradients - does not depend on how the goroutines were scheduled.
+
+A branch has no Rand: which goroutine drew first would change the stream,
+so ops that draw random numbers belong on the main graph.
+*/
+func (g *GraphOf[T]) Branch() *GraphOf[T] {
+	b := &GraphOf[T]{NeedsBackprop: g.NeedsBackprop, Arena: g.Arena}
+	if g.Trace != nil {
+		b.Trace = &TraceOf[T]{}
+	}
+	return b
+}
+
+/*
+Merge appends the ops recorded on each branch, in argument order.
+*/
+func (g *GraphOf[T]) Merge(branches ...*GraphOf[T]) {
+	g.bpMux.Lock()
+	for _, b := range branches {
+		g.Tape = append(g.Tape, b.Tape...)
+		b.Tape = nil
+	}
+	g.bpMux.Unlock()
+	if g.Trace != nil {
+		for _, b := range branches {
+			if b.Trace == nil {
+				continue
+			}
+			for _, op := range b.Trace.Ops {
+				g.Trace.record(op.Op, op.Output, op.Inputs, op.Extra...)
+			}
+		}
+	}
+	for _, b := range branches {
+		for m, rows := range b.touchedRows {
+			for r := range rows {
+				g.touchRows(m, r)
+			}
+		}
+		b.touchedRows = nil
+	}
 	g.bpMux.Lock()
-	g.Backprop = append(g.Backprop, f)
+	for _, b := range branches {
+		for m := range b.denseInputs {
+			if g.denseInputs == nil {
+				g.denseInputs = make(map[*MatOf[T]]bool)
+			}
+			g.denseInputs[m] = true
+		}
+		b.denseInputs = nil
+	}
 	g.bpMux.Unlock()
 }
 
 /*
-Backward runs all backpropagation functions, in order.
+Backward runs the backward pass of every op on the tape, in reverse order.
+The tape stays until the next ResetBackprop, so it can still be looked at
+or replayed, but Backward only runs on it once: again would add every
+gradient twice.
+
+Ops run concurrently when they touch none of the same Mats. Each op waits on
+the closest later op that touched any of its Mats, so every DW gets its
+updates in exactly the order of a sequential run and the gradients are
+bit-identical to one.
+*/
+func (g *GraphOf[T]) Backward() {
+	Assert(!g.backwardRun, "Backward has already run on this tape, ResetBackprop first")
+	g.backwardRun = true
+	g.backward.backward(g.Tape)
+}
+
+/*
+backwardState is what backward schedules the ops of a tape with. A Graph
+keeps one, so the bookkeeping gets reused from tick to tick.
 */
-func (g *Graph) Backward() {
-	var wg sync.WaitGroup
-	totalBackprops := len(g.Backprop)
+type backwardState[T Float] struct {
+	pending     []int32 // how many ops each op still waits on
+	unblocks    [][]int // which ops are waiting on each op
+	lastTouched map[*MatOf[T]]int
+	ready       chan int // ops whose turn it is, then -1 for each thread to stop
+	remaining   int32
+	wg          sync.WaitGroup
+}
+
+/*
+backward runs the backward pass of ops, the way Backward does for a tape.
+*/
+func (b *backwardState[T]) backward(ops []TapeOp[T]) {
+	total := len(ops)
+	if total == 0 {
+		return
+	}
+	threads := concurrentThreads
+	if threads < 2 {
+		for i := total - 1; i >= 0; i-- {
+			ops[i].backward()
+		}
+		return
+	}
+
+	if cap(b.pending) < total {
+		b.pending = make([]int32, total)
+	}
+	if cap(b.unblocks) < total {
+		// keeping the lists there are, they have room already
+		b.unblocks = append(b.unblocks[:cap(b.unblocks)], make([][]int, total-cap(b.unblocks))...)
+	}
+	pending := b.pending[:total]
+	unblocks := b.unblocks[:total]
+	clear(pending)
+	for i := range unblocks {
+		unblocks[i] = unblocks[i][:0]
+	}
+	if b.lastTouched == nil {
+		b.lastTouched = make(map[*MatOf[T]]int)
+	}
+	lastTouched := b.lastTouched
+	clear(lastTouched)
+	var i int
+	touch := func(m *MatOf[T]) {
+		j, seen := lastTouched[m]
+		if seen && j != i {
+			pending[i]++
+			unblocks[j] = append(unblocks[j], i)
+		}
+		lastTouched[m] = i
+	}
+	for i = total - 1; i >= 0; i-- {
+		ops[i].mats(touch)
+	}
+
+	if cap(b.ready) < total+threads {
+		b.ready = make(chan int, total+threads)
+	}
+	for i := total - 1; i >= 0; i-- {
+		if pending[i] == 0 {
+			b.ready <- i
+		}
+	}
+
+	b.remaining = int32(total)
 	// only do as many goroutines at a a time as threads.
 	// too many overloads the runtime with a large and deep neural net.
-	i := totalBackprops - 1
-	for i >= 0 {
-		// TODO: fix data races, somehow
-		for thread := 0; i >= 0 && thread < concurrentThreads; {
-			thread++
-			wg.Add(1)
-			go (func(f int) {
-				fn := g.Backprop[f]
-				wasCreatedInGoneGoroutine := fn == nil
-				if wasCreatedInGoneGoroutine {
-					// Introduced in commit: 7807054d4594a08bdee0412a5782346c579a9b94
-					// Once we started matrix math in parallel during the forward
-					// pass, and some of the results were thrown out, we ended
-					// up with callback functions getting cleaned up, I think.
-					// Probably because the goroutine had been cleaned up, and
-					// the result was only used temporarily inside the goroutine.
-					// This is probably a Go runtime bug, mixed in with an unnecessary
-					// callback - nonetheless easily worked around.
-					wg.Done()
-					return
-				}
-				fn()
-				wg.Done()
-			})(i)
-			i--
+	b.wg.Add(threads)
+	for thread := 0; thread < threads; thread++ {
+		go b.work(ops, threads)
+	}
+	b.wg.Wait()
+}
+
+/*
+work runs the backward pass of ops off the ready channel until it gets a -1.
+*/
+func (b *backwardState[T]) work(ops []TapeOp[T], threads int) {
+	for i := range b.ready {
+		if i < 0 {
+			break
+		}
+		ops[i].backward()
+		for _, k := range b.unblocks[i] {
+			if atomic.AddInt32(&b.pending[k], -1) == 0 {
+				b.ready <- k
+			}
+		}
+		if atomic.AddInt32(&b.remaining, -1) == 0 {
+			for t := 0; t < threads; t++ {
+				b.ready <- -1
+			}
 		}
-		wg.Wait()
 	}
-	g.Backprop = nil
+	b.wg.Done()
 }
 
 /*
 RowPluck plucks a row of m with index `ix` and returns it as col vector.
 */
-func (g *Graph) RowPluck(m *Mat, ix int) *Mat {
+func (g *GraphOf[T]) RowPluck(m *MatOf[T], ix int) *MatOf[T] {
 	Assert(ix >= 0 && ix < m.RowCount, "RowPluck invalid number of rows")
 
+	out := g.run(TapeOp[T]{Kind: OpRowPluck, Output: g.NewMat(m.ColumnCount, 1), Inputs: g.keepMats(m), Ints: g.keepInts(ix)})
+	if g.NeedsBackprop {
+		g.touchRows(m, ix)
+	}
+	return out
+}
+
+func forwardRowPluck[T Float](op *TapeOp[T]) {
+	m, out, ix := op.Inputs[0], op.Output, op.Ints[0]
+	d := m.ColumnCount
+	copy(out.W, m.W[d*ix:d*ix+d]) // copy over the data
+}
+
+func backwardRowPluck[T Float](op *TapeOp[T]) {
+	m, out, ix := op.Inputs[0], op.Output, op.Ints[0]
 	d := m.ColumnCount
-	n := d
-	out := NewMat(d, 1)
+	for j := 0; j < d; j++ {
+		m.DW[d*ix+j] += out.DW[j]
+	}
+}
 
-	for i := 0; i < n; i++ {
-		out.W[i] = m.W[d*ix+i]
-	} // copy over the data
+/*
+RowsPluck is a batched RowPluck (a gather). Column b of the result is row
+ixs[b] of m.
+*/
+func (g *GraphOf[T]) RowsPluck(m *MatOf[T], ixs []int) *MatOf[T] {
+	for _, ix := range ixs {
+		Assert(ix >= 0 && ix < m.RowCount, "RowsPluck invalid number of rows")
+	}
+	ixs = g.keepInts(ixs...) // the caller may reuse its slice before Backward
 
+	out := g.run(TapeOp[T]{Kind: OpRowsPluck, Output: g.NewMat(m.ColumnCount, len(ixs)), Inputs: g.keepMats(m), Ints: ixs})
 	if g.NeedsBackprop {
-		backpropRowPluck := func() {
-			for j := 0; j < n; j++ {
-				m.DW[d*ix+j] += out.DW[j]
-			}
+		g.touchRows(m, ixs...)
+	}
+	return out
+}
+
+func forwardRowsPluck[T Float](op *TapeOp[T]) {
+	m, out, ixs := op.Inputs[0], op.Output, op.Ints
+	d := m.ColumnCount
+	b := len(ixs)
+	for col, ix := range ixs {
+		for i := 0; i < d; i++ {
+			out.W[i*b+col] = m.W[d*ix+i]
 		}
-		g.AddBackprop(backpropRowPluck)
 	}
+}
 
-	return out
+func backwardRowsPluck[T Float](op *TapeOp[T]) {
+	m, out, ixs := op.Inputs[0], op.Output, op.Ints
+	d := m.ColumnCount
+	b := len(ixs)
+	for col, ix := range ixs {
+		for j := 0; j < d; j++ {
+			m.DW[d*ix+j] += out.DW[j*b+col]
+		}
+	}
+}
+
+/*
+unary runs an elementwise op on m.
+*/
+func (g *GraphOf[T]) unary(kind OpKind, m *MatOf[T]) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: kind, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m)})
+}
+
+/*
+binary runs an op on m1 and m2 whose output is shaped like m1.
+*/
+func (g *GraphOf[T]) binary(kind OpKind, m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: kind, Output: g.NewMat(m1.RowCount, m1.ColumnCount), Inputs: g.keepMats(m1, m2)})
 }
 
 /*
 Tanh does tanh nonlinearity
 */
-func (g *Graph) Tanh(m *Mat) *Mat {
-	out := NewMat(m.RowCount, m.ColumnCount)
-	n := len(m.W)
-	for ix := 0; ix < n; ix++ {
-		out.W[ix] = float32(math.Tanh(float64(m.W[ix])))
+func (g *GraphOf[T]) Tanh(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpTanh, m)
+}
+
+func forwardTanh[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for ix, v := range m.W {
+		out.W[ix] = T(math.Tanh(float64(v)))
 	}
+}
 
-	if g.NeedsBackprop {
-		backpropTahn := func() {
-			for i := 0; i < n; i++ {
-				// grad for z = tanh(x) is (1 - z^2)
-				mwi := out.W[i]
-				m.DW[i] += (1.0 - mwi*mwi) * out.DW[i]
-			}
-		}
-		g.AddBackprop(backpropTahn)
+func backwardTanh[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range m.DW {
+		// grad for z = tanh(x) is (1 - z^2)
+		mwi := out.W[i]
+		m.DW[i] += (1.0 - mwi*mwi) * out.DW[i]
 	}
-	return out
 }
 
 /*
 Sigmoid does sigmoid things.
 */
-func (g *Graph) Sigmoid(m *Mat) *Mat {
+func (g *GraphOf[T]) Sigmoid(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpSigmoid, m)
+}
+
+func forwardSigmoid[T Float](op *TapeOp[T]) {
 	// sigmoid nonlinearity
-	out := NewMat(m.RowCount, m.ColumnCount)
-	n := len(m.W)
-	for ix := 0; ix < n; ix++ {
-		out.W[ix] = float32(1.0 / (1 + math.Exp(-float64(m.W[ix]))))
+	m, out := op.Inputs[0], op.Output
+	for ix, v := range m.W {
+		out.W[ix] = T(1.0 / (1 + math.Exp(-float64(v))))
 	}
+}
 
-	if g.NeedsBackprop {
-		backpropSigmoid := func() {
-			for i := 0; i < n; i++ {
-				// grad for z = tanh(x) is (1 - z^2)
-				mwi := out.W[i]
-				m.DW[i] += mwi * (1.0 - mwi) * out.DW[i]
-			}
-		}
-		g.AddBackprop(backpropSigmoid)
+func backwardSigmoid[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range m.DW {
+		// grad for z = sigmoid(x) is z * (1 - z)
+		mwi := out.W[i]
+		m.DW[i] += mwi * (1.0 - mwi) * out.DW[i]
 	}
-
-	return out
 }
 
 /*
 Relu does something
 */
-func (g *Graph) Relu(m *Mat) *Mat {
-	out := NewMat(m.RowCount, m.ColumnCount)
-	n := len(m.W)
-	for ix := 0; ix < n; ix++ {
-		out.W[ix] = float32(math.Max(0, float64(m.W[ix]))) // relu
+func (g *GraphOf[T]) Relu(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpRelu, m)
+}
+
+func forwardRelu[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for ix, v := range m.W {
+		out.W[ix] = T(math.Max(0, float64(v))) // relu
 	}
-	if g.NeedsBackprop {
-		backpropRelu := func() {
-			for i := 0; i < n; i++ {
-				if m.W[i] > 0 {
-					m.DW[i] += out.DW[i]
-				}
-			}
+}
+
+func backwardRelu[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i, v := range m.W {
+		if v > 0 {
+			m.DW[i] += out.DW[i]
 		}
-		g.AddBackprop(backpropRelu)
 	}
-
-	return out
 }
 
 /*
 Mul multiplies two matrices
 */
-func (g *Graph) Mul(m1 *Mat, m2 *Mat) *Mat {
+func (g *GraphOf[T]) Mul(m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
 	Assert(m1.ColumnCount == m2.RowCount, "matmul dimensions misaligned")
+	return g.run(TapeOp[T]{Kind: OpMul, Output: g.NewMat(m1.RowCount, m2.ColumnCount), Inputs: g.keepMats(m1, m2)})
+}
+
+func forwardMul[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	matMul(out.W, m1.W, m2.W, m1.RowCount, m1.ColumnCount, m2.ColumnCount, m1.ColumnCount)
+}
 
-	n := m1.RowCount
+func backwardMul[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	n, k, d := m1.RowCount, m1.ColumnCount, m2.ColumnCount
+	// dm1 = dout * m2^T, dm2 = m1^T * dout
+	matMulABtAdd(m1.DW, out.DW, m2.W, n, k, d, k)
+	matMulAtBAdd(m2.DW, m1.W, out.DW, n, k, d, k)
+}
+
+/*
+MulBlock multiplies the block of m1 in rows [rowFrom, rowTo) and columns
+[colFrom, colTo) by m2, without copying the block out - for when only part of
+a stacked weight matrix applies to an input.
+*/
+func (g *GraphOf[T]) MulBlock(m1 *MatOf[T], rowFrom int, rowTo int, colFrom int, colTo int, m2 *MatOf[T]) *MatOf[T] {
+	Assert(rowFrom >= 0 && rowFrom < rowTo && rowTo <= m1.RowCount, "MulBlock invalid rows")
+	Assert(colFrom >= 0 && colFrom < colTo && colTo <= m1.ColumnCount, "MulBlock invalid columns")
+	Assert(colTo-colFrom == m2.RowCount, "matmul dimensions misaligned")
+	return g.run(TapeOp[T]{
+		Kind:   OpMulBlock,
+		Output: g.NewMat(rowTo-rowFrom, m2.ColumnCount),
+		Inputs: g.keepMats(m1, m2),
+		Ints:   g.keepInts(rowFrom, rowTo, colFrom, colTo),
+	})
+}
+
+/*
+mulBlockRow is row i of the block an OpMulBlock multiplies, in W or DW.
+*/
+func mulBlockRow[T Float](op *TapeOp[T], w []T, i int) []T {
+	k := op.Inputs[0].ColumnCount
+	r := op.Ints[0] + i
+	return w[r*k+op.Ints[2] : r*k+op.Ints[3]]
+}
+
+func forwardMulBlock[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
 	d := m2.ColumnCount
-	out := NewMat(n, d)
-
-	/* original */
-	for row := 0; row < m1.RowCount; row++ { // loop over rows of m1
-		for col := 0; col < m2.ColumnCount; col++ { // loop over cols of m2
-			var cellSum float32 = 0.0
-			for colCell := 0; colCell < m1.ColumnCount; colCell++ { // dot product loop
-				cellSum += m1.W[m1.ColumnCount*row+colCell] * m2.W[m2.ColumnCount*colCell+col]
-			}
-			out.W[d*row+col] = cellSum
+	for i := 0; i < out.RowCount; i++ {
+		row := mulBlockRow(op, m1.W, i)
+		if d == 1 {
+			out.W[i] = dot(row, m2.W)
+			continue
+		}
+		o := out.W[i*d : (i+1)*d]
+		clear(o)
+		for kk, a := range row {
+			axpy(a, m2.W[kk*d:(kk+1)*d], o)
 		}
 	}
+}
 
-	if g.NeedsBackprop {
-		backpropMul := func() {
-			for i := 0; i < m1.RowCount; i++ { // loop over rows of m1
-				// runtime overhead of parallelizing this with goroutines
-				// makes it slower - each backprop func has its own goroutine
-				// already. The next step is SIMD or openCL/GL.
-				for j := 0; j < m2.ColumnCount; j++ { // loop over cols of m2
-					for k := 0; k < m1.ColumnCount; k++ { // dot product loop
-						// reach back through `out` pointer
-						b := out.DW[m2.ColumnCount*i+j]
-						m1.DW[m1.ColumnCount*i+k] += m2.W[m2.ColumnCount*k+j] * b
-						m2.DW[m2.ColumnCount*k+j] += m1.W[m1.ColumnCount*i+k] * b
-					}
-				}
-			}
+func backwardMulBlock[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	d := m2.ColumnCount
+	for i := 0; i < out.RowCount; i++ {
+		row, drow := mulBlockRow(op, m1.W, i), mulBlockRow(op, m1.DW, i)
+		dout := out.DW[i*d : (i+1)*d]
+		if d == 1 {
+			axpy(dout[0], m2.W, drow)
+			axpy(dout[0], row, m2.DW)
+			continue
+		}
+		for kk, a := range row {
+			drow[kk] += dot(dout, m2.W[kk*d:(kk+1)*d])
+			axpy(a, dout, m2.DW[kk*d:(kk+1)*d])
 		}
-		g.AddBackprop(backpropMul)
 	}
-	return out
 }
 
 /*
-Add adds two matrices
+Add adds two matrices. When m2 is a single column and m1 is a batch of
+columns, m2 is broadcast across every column (a bias).
 */
-func (g *Graph) Add(m1 *Mat, m2 *Mat) *Mat {
+func (g *GraphOf[T]) Add(m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
+	if m2.ColumnCount == 1 && m1.ColumnCount > 1 {
+		Assert(m1.RowCount == m2.RowCount, "Cannot broadcast add arrays")
+		return g.binary(OpAddBroadcast, m1, m2)
+	}
 	Assert(len(m1.W) == len(m2.W), "Cannot add arrays")
+	return g.binary(OpAdd, m1, m2)
+}
 
-	out := NewMat(m1.RowCount, m1.ColumnCount)
-	ix := 0
-	n := len(m1.W)
-
-	for ; ix < n; ix++ {
+func forwardAdd[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for ix := range m1.W {
 		out.W[ix] = m1.W[ix] + m2.W[ix]
 	}
-	if g.NeedsBackprop {
-		backpropAdd := func() {
-			last := len(m1.W)
-			for i := 0; i < last; i++ {
-				m1.DW[i] += out.DW[i]
-				m2.DW[i] += out.DW[i]
-			}
+}
+
+func backwardAdd[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range m1.DW {
+		m1.DW[i] += out.DW[i]
+		m2.DW[i] += out.DW[i]
+	}
+}
+
+func forwardAddBroadcast[T Float](op *TapeOp[T]) {
+	m1, col, out := op.Inputs[0], op.Inputs[1], op.Output
+	b := m1.ColumnCount
+	for r := 0; r < m1.RowCount; r++ {
+		v := col.W[r]
+		for j := r * b; j < r*b+b; j++ {
+			out.W[j] = m1.W[j] + v
 		}
-		g.AddBackprop(backpropAdd)
 	}
-	return out
+}
+
+func backwardAddBroadcast[T Float](op *TapeOp[T]) {
+	m1, col, out := op.Inputs[0], op.Inputs[1], op.Output
+	b := m1.ColumnCount
+	for r := 0; r < m1.RowCount; r++ {
+		var sum T
+		for j := r * b; j < r*b+b; j++ {
+			m1.DW[j] += out.DW[j]
+			sum += out.DW[j]
+		}
+		col.DW[r] += sum
+	}
 }
 
 /*
 Eltmul does element-wise multiplication
 */
-func (g *Graph) Eltmul(m1 *Mat, m2 *Mat) *Mat {
+func (g *GraphOf[T]) Eltmul(m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
 	Assert(len(m1.W) == len(m2.W), "Cannot Eltmul")
+	return g.binary(OpEltmul, m1, m2)
+}
 
-	out := NewMat(m1.RowCount, m1.ColumnCount)
-	ix := 0
-	n := len(m1.W)
-	for ; ix < n; ix++ {
+func forwardEltmul[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for ix := range m1.W {
 		out.W[ix] = m1.W[ix] * m2.W[ix]
 	}
-	if g.NeedsBackprop {
-		backpropEtlmul := func() {
-			last := len(m1.W)
-			for i := 0; i < last; i++ {
-				m1.DW[i] += m2.W[i] * out.DW[i]
-				m2.DW[i] += m1.W[i] * out.DW[i]
-			}
-		}
-		g.AddBackprop(backpropEtlmul)
-	}
+}
 
-	return out
+func backwardEltmul[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range m1.DW {
+		m1.DW[i] += m2.W[i] * out.DW[i]
+		m2.DW[i] += m1.W[i] * out.DW[i]
+	}
 }
@@ -0,0 +1,174 @@
+package mat32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+HalfFormat is a 16 bit float format for storing weights. Nothing computes in
+one: weights are expanded to float32 or float64 for the math, and only
+rounded to 16 bits to be kept or saved.
+
+BFloat16 is the top half of a float32 - the same range, but only 8 bits of
+precision. Float16 is IEEE 754 half precision - 11 bits of precision, but
+nothing over 65504 and nothing much under 6e-8.
+*/
+type HalfFormat string
+
+const (
+	BFloat16 HalfFormat = "bf16"
+	Float16  HalfFormat = "f16"
+)
+
+/*
+ParseHalfFormat reads "bf16" or "f16".
+*/
+func ParseHalfFormat(s string) (HalfFormat, error) {
+	switch f := HalfFormat(s); f {
+	case BFloat16, Float16:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown half precision format %q, want bf16 or f16", s)
+}
+
+/*
+FromFloat32 rounds v to the nearest value of the format, ties to even.
+*/
+func (f HalfFormat) FromFloat32(v float32) uint16 {
+	if f == BFloat16 {
+		return float32ToBFloat16(v)
+	}
+	return float32ToFloat16(v)
+}
+
+/*
+ToFloat32 is the value of h, exactly.
+*/
+func (f HalfFormat) ToFloat32(h uint16) float32 {
+	if f == BFloat16 {
+		return math.Float32frombits(uint32(h) << 16)
+	}
+	return float16ToFloat32(h)
+}
+
+func float32ToBFloat16(v float32) uint16 {
+	b := math.Float32bits(v)
+	if v != v { // NaN, which rounding could turn into infinity
+		return uint16(b>>16) | 0x40
+	}
+	// round to nearest even on the 16 bits that get dropped
+	b += 0x7fff + (b>>16)&1
+	return uint16(b >> 16)
+}
+
+func float32ToFloat16(v float32) uint16 {
+	b := math.Float32bits(v)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23) & 0xff
+	mant := b & 0x7fffff
+
+	if exp == 0xff { // infinity or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	e := exp - 127 + 15
+	if e >= 0x1f { // too big, infinity
+		return sign | 0x7c00
+	}
+	if e <= 0 {
+		// a subnormal half, or zero
+		if e < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - e)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(e)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // a carry into the exponent is right, up to infinity
+	}
+	return sign | uint16(half)
+}
+
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f: // infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// subnormal, normalize it
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+/*
+RoundHalf rounds every value of w to the nearest one f can store, in place.
+*/
+func RoundHalf[T Float](w []T, f HalfFormat) {
+	for i, v := range w {
+		w[i] = T(f.ToFloat32(f.FromFloat32(float32(v))))
+	}
+}
+
+/*
+HalfMat is the weights of a Mat stored in a HalfFormat, two bytes each - in
+JSON, base64 of them little endian. It has no gradients; expand it with
+FromHalfMat to compute with it.
+*/
+type HalfMat struct {
+	RowCount    int
+	ColumnCount int
+	Format      HalfFormat
+	W           []byte
+}
+
+/*
+ToHalfMat rounds the weights of m into a HalfMat of format f.
+*/
+func ToHalfMat[T Float](m *MatOf[T], f HalfFormat) *HalfMat {
+	h := &HalfMat{
+		RowCount:    m.RowCount,
+		ColumnCount: m.ColumnCount,
+		Format:      f,
+		W:           make([]byte, 2*len(m.W)),
+	}
+	for i, v := range m.W {
+		binary.LittleEndian.PutUint16(h.W[2*i:], f.FromFloat32(float32(v)))
+	}
+	return h
+}
+
+/*
+FromHalfMat expands h into a Mat of T.
+*/
+func FromHalfMat[T Float](h *HalfMat) *MatOf[T] {
+	Assert(len(h.W) == 2*h.RowCount*h.ColumnCount, "HalfMat size does not match its shape")
+	m := NewMatOf[T](h.RowCount, h.ColumnCount)
+	for i := range m.W {
+		m.W[i] = T(h.Format.ToFloat32(binary.LittleEndian.Uint16(h.W[2*i:])))
+	}
+	return m
+}
@@ -0,0 +1,210 @@
+package mat32
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+/*
+Initializer fills the starting weights of a rows x cols matrix. Weight
+matrices are multiplied as W * x, so the fan in is cols and the fan out is
+rows.
+*/
+type Initializer interface {
+	Fill(r *rand.Rand, w []float64, rows int, cols int)
+	String() string
+}
+
+/*
+Uniform draws from uniform(-Scale, Scale).
+*/
+type Uniform struct{ Scale float64 }
+
+func (u Uniform) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	for i := range w {
+		w[i] = (r.Float64()*2 - 1) * u.Scale
+	}
+}
+
+func (u Uniform) String() string { return "uniform:" + formatFloat(u.Scale) }
+
+/*
+Normal draws from a normal distribution with mean 0 and standard deviation Std.
+*/
+type Normal struct{ Std float64 }
+
+func (n Normal) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	for i := range w {
+		w[i] = r.NormFloat64() * n.Std
+	}
+}
+
+func (n Normal) String() string { return "normal:" + formatFloat(n.Std) }
+
+/*
+Xavier is Glorot & Bengio's uniform initialization, scaled so activations
+keep their variance going forwards and backwards through tanh layers.
+*/
+type Xavier struct{}
+
+func (Xavier) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	Uniform{Scale: math.Sqrt(6 / float64(rows+cols))}.Fill(r, w, rows, cols)
+}
+
+func (Xavier) String() string { return "xavier" }
+
+/*
+He is He et al.'s normal initialization for relu layers.
+*/
+type He struct{}
+
+func (He) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	Normal{Std: math.Sqrt(2 / float64(cols))}.Fill(r, w, rows, cols)
+}
+
+func (He) String() string { return "he" }
+
+/*
+Orthogonal makes a random matrix with orthonormal rows (or columns, when
+there are more rows than columns), times Gain. It is the usual choice for
+recurrent weights, since repeatedly multiplying by an orthogonal matrix
+neither blows up nor shrinks the hidden state.
+*/
+type Orthogonal struct{ Gain float64 }
+
+func (o Orthogonal) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	long, short := cols, rows
+	if rows > cols {
+		long, short = rows, cols
+	}
+	// Gram-Schmidt on `short` random vectors of length `long`
+	vecs := make([][]float64, short)
+	for v := range vecs {
+		vec := make([]float64, long)
+		for {
+			for i := range vec {
+				vec[i] = r.NormFloat64()
+			}
+			for _, prev := range vecs[:v] {
+				var dot float64
+				for i := range vec {
+					dot += vec[i] * prev[i]
+				}
+				for i := range vec {
+					vec[i] -= dot * prev[i]
+				}
+			}
+			var norm float64
+			for i := range vec {
+				norm += vec[i] * vec[i]
+			}
+			norm = math.Sqrt(norm)
+			if norm > 1e-6 { // otherwise it was (nearly) dependent, draw again
+				for i := range vec {
+					vec[i] /= norm
+				}
+				break
+			}
+		}
+		vecs[v] = vec
+	}
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if rows <= cols {
+				w[i*cols+j] = vecs[i][j] * o.Gain
+			} else {
+				w[i*cols+j] = vecs[j][i] * o.Gain
+			}
+		}
+	}
+}
+
+func (o Orthogonal) String() string { return "orthogonal:" + formatFloat(o.Gain) }
+
+/*
+Constant sets every weight to Value, as for a bias or a layer norm gain.
+*/
+type Constant struct{ Value float64 }
+
+func (c Constant) Fill(r *rand.Rand, w []float64, rows int, cols int) {
+	for i := range w {
+		w[i] = c.Value
+	}
+}
+
+func (c Constant) String() string { return "constant:" + formatFloat(c.Value) }
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+/*
+ParseInitializer reads an initializer written like its String: "uniform:0.08",
+"normal:0.01", "xavier", "he", "orthogonal" (gain 1) or "orthogonal:1.2",
+and "constant:1".
+*/
+func ParseInitializer(s string) (Initializer, error) {
+	name, arg, hasArg := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	value := 1.0
+	if hasArg {
+		var err error
+		value, err = strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("initializer %q: bad number %q", s, arg)
+		}
+	}
+	needsArg := func() error {
+		if !hasArg {
+			return fmt.Errorf("initializer %q needs a value, like %s:0.08", s, name)
+		}
+		return nil
+	}
+
+	switch name {
+	case "uniform":
+		if err := needsArg(); err != nil {
+			return nil, err
+		}
+		return Uniform{Scale: value}, nil
+	case "normal":
+		if err := needsArg(); err != nil {
+			return nil, err
+		}
+		return Normal{Std: value}, nil
+	case "constant":
+		if err := needsArg(); err != nil {
+			return nil, err
+		}
+		return Constant{Value: value}, nil
+	case "orthogonal":
+		return Orthogonal{Gain: value}, nil
+	case "xavier", "glorot":
+		if hasArg {
+			return nil, fmt.Errorf("initializer %q takes no value", s)
+		}
+		return Xavier{}, nil
+	case "he":
+		if hasArg {
+			return nil, fmt.Errorf("initializer %q takes no value", s)
+		}
+		return He{}, nil
+	}
+	return nil, fmt.Errorf("unknown initializer %q, want uniform, normal, xavier, he, orthogonal or constant", s)
+}
+
+/*
+InitMatOf makes an n x d matrix of T with weights from init, drawing from r.
+*/
+func InitMatOf[T Float](r *rand.Rand, n int, d int, init Initializer) *MatOf[T] {
+	w := make([]float64, n*d)
+	init.Fill(r, w, n, d)
+	m := NewMatOf[T](n, d)
+	for i := range w {
+		m.W[i] = T(w[i])
+	}
+	return m
+}
@@ -0,0 +1,78 @@
+package mat32
+
+import "math"
+
+/*
+layerNormEPS keeps the normalization finite for a column with no variance.
+*/
+const layerNormEPS = 1e-5
+
+/*
+LayerNorm normalizes each column of m to zero mean and unit variance over its
+rows, then scales and shifts it by the learnable column vectors gain and bias
+(each m.RowCount x 1), which are shared by every column of a batch.
+*/
+func (g *GraphOf[T]) LayerNorm(m *MatOf[T], gain *MatOf[T], bias *MatOf[T]) *MatOf[T] {
+	Assert(len(gain.W) == m.RowCount && len(bias.W) == m.RowCount, "LayerNorm gain and bias must have one value per row")
+	xhat := g.floats(len(m.W)) // normalized input, kept for backprop
+	invStd := g.floats(m.ColumnCount)
+	return g.run(TapeOp[T]{
+		Kind:   OpLayerNorm,
+		Output: g.NewMat(m.RowCount, m.ColumnCount),
+		Inputs: g.keepMats(m, gain, bias),
+		Saved:  g.keepSaved(xhat, invStd),
+	})
+}
+
+func forwardLayerNorm[T Float](op *TapeOp[T]) {
+	m, gain, bias, out := op.Inputs[0], op.Inputs[1], op.Inputs[2], op.Output
+	xhat, invStd := op.Saved[0], op.Saved[1]
+	n := m.RowCount
+	b := m.ColumnCount
+	for col := 0; col < b; col++ {
+		var mean float64
+		for i := col; i < n*b; i += b {
+			mean += float64(m.W[i])
+		}
+		mean /= float64(n)
+		var variance float64
+		for i := col; i < n*b; i += b {
+			diff := float64(m.W[i]) - mean
+			variance += diff * diff
+		}
+		variance /= float64(n)
+		invStd[col] = T(1 / math.Sqrt(variance+layerNormEPS))
+
+		for r := 0; r < n; r++ {
+			i := r*b + col
+			xhat[i] = T(float64(m.W[i])-mean) * invStd[col]
+			out.W[i] = gain.W[r]*xhat[i] + bias.W[r]
+		}
+	}
+}
+
+func backwardLayerNorm[T Float](op *TapeOp[T]) {
+	m, gain, bias, out := op.Inputs[0], op.Inputs[1], op.Inputs[2], op.Output
+	xhat, invStd := op.Saved[0], op.Saved[1]
+	n := m.RowCount
+	b := m.ColumnCount
+	for col := 0; col < b; col++ {
+		// dx = invStd * (dxhat - mean(dxhat) - xhat * mean(dxhat * xhat))
+		var sumDXhat, sumDXhatXhat T
+		for r := 0; r < n; r++ {
+			i := r*b + col
+			dxhat := out.DW[i] * gain.W[r]
+			sumDXhat += dxhat
+			sumDXhatXhat += dxhat * xhat[i]
+			gain.DW[r] += out.DW[i] * xhat[i]
+			bias.DW[r] += out.DW[i]
+		}
+		meanDXhat := sumDXhat / T(n)
+		meanDXhatXhat := sumDXhatXhat / T(n)
+		for r := 0; r < n; r++ {
+			i := r*b + col
+			dxhat := out.DW[i] * gain.W[r]
+			m.DW[i] += invStd[col] * (dxhat - meanDXhat - xhat[i]*meanDXhatXhat)
+		}
+	}
+}
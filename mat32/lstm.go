@@ -0,0 +1,216 @@
+package mat32
+
+import "math"
+
+/*
+LSTMCell is one timestep of a standard LSTM cell as a single op, with a
+single hand written backprop instead of the twenty or so ops it takes to
+build one out of Mul, Add, Sigmoid, Tanh and Eltmul.
+
+W stacks the weights of the input, forget and output gates and the cell
+write, in that order, as 4H rows over the columns of [x; hPrev]. b stacks
+their biases the same way and is 4H x 1. The gates are sigmoids and the cell
+write and cell output are tanh:
+
+	i, f, o, g = sigmoid, sigmoid, sigmoid, tanh of W * [x; hPrev] + b
+	c = f * cPrev + i * g
+	h = o * tanh(c)
+
+x may be a batch of columns, with hPrev and cPrev having as many.
+*/
+func (g *GraphOf[T]) LSTMCell(W *MatOf[T], b *MatOf[T], x *MatOf[T], hPrev *MatOf[T], cPrev *MatOf[T]) (h *MatOf[T], c *MatOf[T]) {
+	return g.lstmCell(OpLSTMCell, W, nil, b, x, hPrev, cPrev)
+}
+
+/*
+SimplifiedLSTMCell is LSTMCell for the simplified LSTM (LSTM2, Lu & Salem,
+2017), where the three gates only see hPrev and have no bias. W and b keep
+the same layout; the gates' x columns and biases are just never used.
+*/
+func (g *GraphOf[T]) SimplifiedLSTMCell(W *MatOf[T], b *MatOf[T], x *MatOf[T], hPrev *MatOf[T], cPrev *MatOf[T]) (h *MatOf[T], c *MatOf[T]) {
+	return g.lstmCell(OpSimplifiedLSTMCell, W, nil, b, x, hPrev, cPrev)
+}
+
+/*
+QuantLSTMCell is LSTMCell with int8 weights, for inference only.
+*/
+func (g *GraphOf[T]) QuantLSTMCell(W *QuantMat, b *MatOf[T], x *MatOf[T], hPrev *MatOf[T], cPrev *MatOf[T]) (h *MatOf[T], c *MatOf[T]) {
+	return g.lstmCell(OpLSTMCell, nil, W, b, x, hPrev, cPrev)
+}
+
+/*
+QuantSimplifiedLSTMCell is SimplifiedLSTMCell with int8 weights, for
+inference only.
+*/
+func (g *GraphOf[T]) QuantSimplifiedLSTMCell(W *QuantMat, b *MatOf[T], x *MatOf[T], hPrev *MatOf[T], cPrev *MatOf[T]) (h *MatOf[T], c *MatOf[T]) {
+	return g.lstmCell(OpSimplifiedLSTMCell, nil, W, b, x, hPrev, cPrev)
+}
+
+/*
+lstmCell is every kind of LSTM cell op. The weights are either W or, for
+inference, the quantized Wq, which never goes on the tape.
+
+The op saves [x; hPrev], the gate activations and tanh of the cell, and when
+there is backprop to do, scratch space for it.
+*/
+func (g *GraphOf[T]) lstmCell(kind OpKind, W *MatOf[T], Wq *QuantMat, b *MatOf[T], x *MatOf[T], hPrev *MatOf[T], cPrev *MatOf[T]) (h *MatOf[T], c *MatOf[T]) {
+	nx := x.RowCount
+	nh := hPrev.RowCount
+	batch := x.ColumnCount
+	var rows, cols int
+	if Wq != nil {
+		Assert(!g.NeedsBackprop, "Quant"+kind.String()+" has no backprop")
+		rows, cols = Wq.RowCount, Wq.ColumnCount
+	} else {
+		rows, cols = W.RowCount, W.ColumnCount
+	}
+	Assert(rows == 4*nh && cols == nx+nh, "LSTMCell W must be 4H x (X+H)")
+	Assert(b.RowCount == 4*nh && b.ColumnCount == 1, "LSTMCell b must be 4H x 1")
+	Assert(hPrev.ColumnCount == batch && cPrev.RowCount == nh && cPrev.ColumnCount == batch, "LSTMCell state does not match x")
+
+	size := nh * batch
+	saved := [5][]T{g.floats((nx + nh) * batch), g.floats(4 * size), g.floats(size)}
+	kept := 3
+	if g.NeedsBackprop {
+		saved[3], saved[4] = g.floats(4*size), g.floats((nx+nh)*batch)
+		kept = 5
+	}
+	op := TapeOp[T]{
+		Kind:   kind,
+		Output: g.NewMat(nh, batch),
+		Extra:  g.keepMats(g.NewMat(nh, batch)),
+		Inputs: g.keepMats(W, b, x, hPrev, cPrev),
+		Saved:  g.keepSaved(saved[:kept]...),
+	}
+	if Wq != nil {
+		forwardLSTMCell(&op, Wq)
+		return op.Output, op.Extra[0]
+	}
+	return g.run(op), op.Extra[0]
+}
+
+/*
+forwardLSTMCell is the forward pass of an LSTM cell op, multiplying by the
+quantized Wq instead of its W when Wq is given.
+*/
+func forwardLSTMCell[T Float](op *TapeOp[T], Wq *QuantMat) {
+	W, b, x, hPrev, cPrev := op.Inputs[0], op.Inputs[1], op.Inputs[2], op.Inputs[3], op.Inputs[4]
+	h, c := op.Output, op.Extra[0]
+	xh, gates, tanhCell := op.Saved[0], op.Saved[1], op.Saved[2]
+	nx := x.RowCount
+	nh := hPrev.RowCount
+	batch := x.ColumnCount
+	simplified := op.Kind == OpSimplifiedLSTMCell
+
+	// [x; hPrev], which is just one after the other in row-major order
+	copy(xh, x.W)
+	copy(xh[len(x.W):], hPrev.W)
+
+	// gate activations, kept for backprop. The simplified gates only
+	// multiply the hPrev columns of their rows of W.
+	k := nx + nh
+	switch {
+	case Wq != nil && simplified:
+		quantMatMul(gates[:3*nh*batch], Wq, 0, 3*nh, nx, k, hPrev.W, batch)
+	case Wq != nil:
+		quantMatMul(gates[:3*nh*batch], Wq, 0, 3*nh, 0, k, xh, batch)
+	case simplified:
+		matMul(gates[:3*nh*batch], W.W[nx:3*nh*k], hPrev.W, 3*nh, nh, batch, k)
+	default:
+		matMul(gates[:3*nh*batch], W.W[:3*nh*k], xh, 3*nh, k, batch, k)
+	}
+	if Wq != nil {
+		quantMatMul(gates[3*nh*batch:], Wq, 3*nh, 4*nh, 0, k, xh, batch)
+	} else {
+		matMul(gates[3*nh*batch:], W.W[3*nh*k:], xh, nh, k, batch, k)
+	}
+	for r := 0; r < 4*nh; r++ {
+		bias := b.W[r]
+		if simplified && r < 3*nh {
+			bias = 0
+		}
+		row := gates[r*batch : (r+1)*batch]
+		for j := range row {
+			z := float64(row[j] + bias)
+			if r < 3*nh {
+				row[j] = T(1 / (1 + math.Exp(-z)))
+			} else {
+				row[j] = T(math.Tanh(z))
+			}
+		}
+	}
+	size := nh * batch
+	inputGate := gates[:size]
+	forgetGate := gates[size : 2*size]
+	outputGate := gates[2*size : 3*size]
+	cellWrite := gates[3*size:]
+
+	for i := 0; i < size; i++ {
+		c.W[i] = forgetGate[i]*cPrev.W[i] + inputGate[i]*cellWrite[i]
+		tanhCell[i] = T(math.Tanh(float64(c.W[i])))
+		h.W[i] = outputGate[i] * tanhCell[i]
+	}
+}
+
+func backwardLSTMCell[T Float](op *TapeOp[T]) {
+	W, b, x, hPrev, cPrev := op.Inputs[0], op.Inputs[1], op.Inputs[2], op.Inputs[3], op.Inputs[4]
+	h, c := op.Output, op.Extra[0]
+	xh, gates, tanhCell := op.Saved[0], op.Saved[1], op.Saved[2]
+	dgates, dxh := op.Saved[3], op.Saved[4]
+	nx := x.RowCount
+	nh := hPrev.RowCount
+	batch := x.ColumnCount
+	simplified := op.Kind == OpSimplifiedLSTMCell
+
+	size := nh * batch
+	inputGate := gates[:size]
+	forgetGate := gates[size : 2*size]
+	outputGate := gates[2*size : 3*size]
+	cellWrite := gates[3*size:]
+	di := dgates[:size]
+	df := dgates[size : 2*size]
+	do := dgates[2*size : 3*size]
+	dg := dgates[3*size:]
+	for i := 0; i < size; i++ {
+		// the cell gets its own gradient plus what came through h
+		dc := c.DW[i] + h.DW[i]*outputGate[i]*(1-tanhCell[i]*tanhCell[i])
+		cPrev.DW[i] += dc * forgetGate[i]
+		// back through the gate nonlinearities to the pre-activations
+		do[i] = h.DW[i] * tanhCell[i] * outputGate[i] * (1 - outputGate[i])
+		di[i] = dc * cellWrite[i] * inputGate[i] * (1 - inputGate[i])
+		df[i] = dc * cPrev.W[i] * forgetGate[i] * (1 - forgetGate[i])
+		dg[i] = dc * inputGate[i] * (1 - cellWrite[i]*cellWrite[i])
+	}
+	for r := 0; r < 4*nh; r++ {
+		if simplified && r < 3*nh {
+			continue
+		}
+		var sum T
+		for _, v := range dgates[r*batch : (r+1)*batch] {
+			sum += v
+		}
+		b.DW[r] += sum
+	}
+	// dW = dgates * [x; hPrev]^T, d[x; hPrev] = W^T * dgates, a block of
+	// gate rows and a block of cell write rows. The simplified gates' x
+	// columns get no gradient, and only hPrev gets one from the gates.
+	k := nx + nh
+	dgate := dgates[:3*size]
+	dwrite := dgates[3*size:]
+	matMulABtAdd(W.DW[3*nh*k:], dwrite, xh, nh, k, batch, k)
+	clear(dxh)
+	matMulAtBAdd(dxh, W.W[3*nh*k:], dwrite, nh, k, batch, k)
+	if simplified {
+		matMulABtAdd(W.DW[nx:3*nh*k], dgate, hPrev.W, 3*nh, nh, batch, k)
+		matMulAtBAdd(dxh[len(x.W):], W.W[nx:3*nh*k], dgate, 3*nh, nh, batch, k)
+	} else {
+		matMulABtAdd(W.DW[:3*nh*k], dgate, xh, 3*nh, k, batch, k)
+		matMulAtBAdd(dxh, W.W[:3*nh*k], dgate, 3*nh, k, batch, k)
+	}
+	for i := range x.DW {
+		x.DW[i] += dxh[i]
+	}
+	for i := range hPrev.DW {
+		hPrev.DW[i] += dxh[len(x.DW)+i]
+	}
+}
@@ -0,0 +1,221 @@
+package mat32
+
+import (
+	"sync"
+	"unsafe"
+)
+
+/*
+Tile sizes for the blocked matrix multiply kernels. A tile of the right hand
+matrix is blockInner rows of tileBytes, small enough to stay in L2 while the
+rows of the left hand matrix stream past it. How many columns that is
+depends on T; see blockCols.
+*/
+const (
+	blockInner = 128
+	tileBytes  = 128 << 10
+)
+
+/*
+blockCols is how many columns of T make a tile tileBytes: 256 of float32,
+128 of float64.
+*/
+func blockCols[T Float]() int {
+	var zero T
+	return tileBytes / (blockInner * int(unsafe.Sizeof(zero)))
+}
+
+/*
+parallelMinWork is roughly how many multiply-adds a product needs before it
+is worth splitting across goroutines.
+*/
+const parallelMinWork = 1 << 16
+
+/*
+parallel tells whether a product of work multiply-adds over rows is worth
+splitting across goroutines.
+*/
+func parallel(rows int, work int) bool {
+	return work >= parallelMinWork && concurrentThreads >= 2 && rows >= 2
+}
+
+/*
+parallelRows calls fn over [0, rows), split into one chunk per thread.
+Chunks never overlap, so a kernel that only writes its own rows stays
+deterministic.
+
+The kernels only make the closure for it once parallel says so, since a
+closure that goes to a goroutine is a heap allocation, and most products in
+a tick are too small to split.
+*/
+func parallelRows(rows int, fn func(from int, to int)) {
+	threads := min(concurrentThreads, rows)
+	chunk := (rows + threads - 1) / threads
+	var wg sync.WaitGroup
+	for from := 0; from < rows; from += chunk {
+		to := from + chunk
+		if to > rows {
+			to = rows
+		}
+		wg.Add(1)
+		go (func(from int, to int) {
+			fn(from, to)
+			wg.Done()
+		})(from, to)
+	}
+	wg.Wait()
+}
+
+/*
+dot is the dot product of two equal length slices.
+*/
+func dot[T Float](a []T, b []T) T {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 T
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+/*
+axpy does y += alpha * x.
+*/
+func axpy[T Float](alpha T, x []T, y []T) {
+	y = y[:len(x)]
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		y[i] += alpha * x[i]
+		y[i+1] += alpha * x[i+1]
+		y[i+2] += alpha * x[i+2]
+		y[i+3] += alpha * x[i+3]
+	}
+	for ; i < len(x); i++ {
+		y[i] += alpha * x[i]
+	}
+}
+
+/*
+matMul sets out (n x d) to a (n x k) times b (k x d).
+
+In all three kernels ldk is how far apart the rows of the n x k matrix are,
+which is k unless it is a block of columns of a wider one.
+*/
+func matMul[T Float](out []T, a []T, b []T, n int, k int, d int, ldk int) {
+	if !parallel(n, n*k*d) {
+		matMulRows(out, a, b, k, d, ldk, 0, n)
+		return
+	}
+	parallelRows(n, func(from int, to int) { matMulRows(out, a, b, k, d, ldk, from, to) })
+}
+
+/*
+matMulRows is matMul for rows [from, to) of out.
+*/
+func matMulRows[T Float](out []T, a []T, b []T, k int, d int, ldk int, from int, to int) {
+	if d == 1 {
+		// matrix-vector, the common case: one dot product per row
+		for i := from; i < to; i++ {
+			out[i] = dot(a[i*ldk:i*ldk+k], b)
+		}
+		return
+	}
+	for i := from; i < to; i++ {
+		row := out[i*d : i*d+d]
+		for j := range row {
+			row[j] = 0
+		}
+	}
+	// walk b a tile at a time so it stays in cache, and only ever
+	// along its rows
+	cols := blockCols[T]()
+	for k0 := 0; k0 < k; k0 += blockInner {
+		k1 := min(k0+blockInner, k)
+		for j0 := 0; j0 < d; j0 += cols {
+			j1 := min(j0+cols, d)
+			for i := from; i < to; i++ {
+				row := out[i*d+j0 : i*d+j1]
+				for kk := k0; kk < k1; kk++ {
+					axpy(a[i*ldk+kk], b[kk*d+j0:kk*d+j1], row)
+				}
+			}
+		}
+	}
+}
+
+/*
+matMulABtAdd adds a (n x d) times the transpose of b (k x d) into out (n x k).
+This is the gradient of the left operand of a multiply.
+*/
+func matMulABtAdd[T Float](out []T, a []T, b []T, n int, k int, d int, ldk int) {
+	if !parallel(n, n*k*d) {
+		matMulABtAddRows(out, a, b, k, d, ldk, 0, n)
+		return
+	}
+	parallelRows(n, func(from int, to int) { matMulABtAddRows(out, a, b, k, d, ldk, from, to) })
+}
+
+/*
+matMulABtAddRows is matMulABtAdd for rows [from, to) of out.
+*/
+func matMulABtAddRows[T Float](out []T, a []T, b []T, k int, d int, ldk int, from int, to int) {
+	if d == 1 {
+		// outer product
+		for i := from; i < to; i++ {
+			axpy(a[i], b[:k], out[i*ldk:i*ldk+k])
+		}
+		return
+	}
+	// both operands are read along their rows
+	for k0 := 0; k0 < k; k0 += blockInner {
+		k1 := min(k0+blockInner, k)
+		for i := from; i < to; i++ {
+			arow := a[i*d : i*d+d]
+			for kk := k0; kk < k1; kk++ {
+				out[i*ldk+kk] += dot(arow, b[kk*d:kk*d+d])
+			}
+		}
+	}
+}
+
+/*
+matMulAtBAdd adds the transpose of a (n x k) times b (n x d) into out (k x d).
+This is the gradient of the right operand of a multiply.
+*/
+func matMulAtBAdd[T Float](out []T, a []T, b []T, n int, k int, d int, ldk int) {
+	// split on the rows of out so no two goroutines write the same element
+	if !parallel(k, n*k*d) {
+		matMulAtBAddRows(out, a, b, n, d, ldk, 0, k)
+		return
+	}
+	parallelRows(k, func(from int, to int) { matMulAtBAddRows(out, a, b, n, d, ldk, from, to) })
+}
+
+/*
+matMulAtBAddRows is matMulAtBAdd for rows [from, to) of out.
+*/
+func matMulAtBAddRows[T Float](out []T, a []T, b []T, n int, d int, ldk int, from int, to int) {
+	if d == 1 {
+		for i := 0; i < n; i++ {
+			axpy(b[i], a[i*ldk+from:i*ldk+to], out[from:to])
+		}
+		return
+	}
+	cols := blockCols[T]()
+	for j0 := 0; j0 < d; j0 += cols {
+		j1 := min(j0+cols, d)
+		for i := 0; i < n; i++ {
+			brow := b[i*d+j0 : i*d+j1]
+			for kk := from; kk < to; kk++ {
+				axpy(a[i*ldk+kk], brow, out[kk*d+j0:kk*d+j1])
+			}
+		}
+	}
+}
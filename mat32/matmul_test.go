@@ -0,0 +1,192 @@
+package mat32
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+matMulShapes are n, k, d for a (n x k) times b (k x d). They cover vectors,
+sizes that are not a multiple of the tiles, and products big enough to be
+split across goroutines.
+*/
+var matMulShapes = [][3]int{
+	{1, 1, 1},
+	{3, 5, 1},
+	{7, 3, 2},
+	{5, 9, 7},
+	{100, 75, 100},
+	{400, 175, 1},
+	{130, blockInner + 3, blockCols[float32]() + 5},
+	{3, 2*blockInner + 1, 2*blockCols[float32]() + 1},
+}
+
+func randFloats[T Float](r *rand.Rand, n int) []T {
+	s := make([]T, n)
+	for i := range s {
+		s[i] = T(r.Float64()*2 - 1)
+	}
+	return s
+}
+
+/*
+naiveMatMul is the triple loop the kernels replaced, in float64, with a and
+b each optionally transposed.
+*/
+func naiveMatMul[T Float](a []T, b []T, n int, k int, d int, at func(i, kk int) int, bt func(kk, j int) int) []float64 {
+	out := make([]float64, n*d)
+	for i := 0; i < n; i++ {
+		for j := 0; j < d; j++ {
+			var s float64
+			for kk := 0; kk < k; kk++ {
+				s += float64(a[at(i, kk)]) * float64(b[bt(kk, j)])
+			}
+			out[i*d+j] = s
+		}
+	}
+	return out
+}
+
+func checkClose[T Float](t *testing.T, name string, got []T, want []float64, k int) {
+	t.Helper()
+	// k products summed in a different order, each about 1 in size
+	tol := float64(k) * 1e-6
+	if _, ok := any(got[0]).(float64); ok {
+		tol = float64(k) * 1e-13
+	}
+	for i := range want {
+		if d := math.Abs(float64(got[i]) - want[i]); d > tol || math.IsNaN(d) {
+			t.Fatalf("%s: element %d is %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func testMatMul[T Float](t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, s := range matMulShapes {
+		n, k, d := s[0], s[1], s[2]
+		// the n x k operand packed, and as a block of columns of a wider one
+		for _, pad := range []int{0, 3} {
+			ldk := k + pad
+			name := func(kernel string) string { return fmt.Sprintf("%s %dx%dx%d ldk=%d", kernel, n, k, d, ldk) }
+			a := randFloats[T](r, n*ldk)
+			b := randFloats[T](r, k*d)
+
+			out := randFloats[T](r, n*d) // matMul overwrites
+			matMul(out, a, b, n, k, d, ldk)
+			want := naiveMatMul(a, b, n, k, d,
+				func(i, kk int) int { return i*ldk + kk },
+				func(kk, j int) int { return kk*d + j })
+			checkClose(t, name("matMul"), out, want, k)
+
+			// dm1 += dout * m2^T, with dout n x d and m2 k x d
+			dout := randFloats[T](r, n*d)
+			base := randFloats[T](r, n*ldk)
+			got := append([]T(nil), base...)
+			matMulABtAdd(got, dout, b, n, k, d, ldk)
+			want = naiveMatMul(dout, b, n, d, k,
+				func(i, j int) int { return i*d + j },
+				func(j, kk int) int { return kk*d + j })
+			for i := 0; i < n; i++ {
+				for kk := 0; kk < k; kk++ {
+					want[i*k+kk] += float64(base[i*ldk+kk])
+				}
+				for kk := k; kk < ldk; kk++ {
+					if got[i*ldk+kk] != base[i*ldk+kk] {
+						t.Fatalf("%s wrote to the padding of row %d", name("matMulABtAdd"), i)
+					}
+				}
+			}
+			packed := make([]T, 0, n*k)
+			for i := 0; i < n; i++ {
+				packed = append(packed, got[i*ldk:i*ldk+k]...)
+			}
+			checkClose(t, name("matMulABtAdd"), packed, want, d)
+
+			// dm2 += m1^T * dout
+			base = randFloats[T](r, k*d)
+			got = append([]T(nil), base...)
+			matMulAtBAdd(got, a, dout, n, k, d, ldk)
+			want = naiveMatMul(a, dout, k, n, d,
+				func(kk, i int) int { return i*ldk + kk },
+				func(i, j int) int { return i*d + j })
+			for i := range want {
+				want[i] += float64(base[i])
+			}
+			checkClose(t, name("matMulAtBAdd"), got, want, n)
+		}
+	}
+}
+
+/*
+withThreads runs fn with concurrentThreads set to threads, so the parallel
+split gets run whatever the machine has.
+*/
+func withThreads(threads int, fn func()) {
+	old := concurrentThreads
+	concurrentThreads = threads
+	defer func() { concurrentThreads = old }()
+	fn()
+}
+
+func TestMatMul(t *testing.T) {
+	for _, threads := range []int{1, 3, 8} {
+		withThreads(threads, func() {
+			t.Run(fmt.Sprintf("float32/threads=%d", threads), testMatMul[float32])
+			t.Run(fmt.Sprintf("float64/threads=%d", threads), testMatMul[float64])
+		})
+	}
+}
+
+/*
+naiveMul is the multiply and backprop Mul used to do, walking m2 by column.
+*/
+func naiveMul(out, dout, m1, dm1, m2, dm2 []float32, n int, k int, d int) {
+	for i := 0; i < n; i++ {
+		for j := 0; j < d; j++ {
+			var dot float32
+			for kk := 0; kk < k; kk++ {
+				dot += m1[i*k+kk] * m2[kk*d+j]
+			}
+			out[i*d+j] = dot
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < d; j++ {
+			b := dout[i*d+j]
+			for kk := 0; kk < k; kk++ {
+				dm1[i*k+kk] += m2[kk*d+j] * b
+				dm2[kk*d+j] += m1[i*k+kk] * b
+			}
+		}
+	}
+}
+
+/*
+BenchmarkMul runs a forward and backward multiply with the old triple loop
+and with the kernels, at the shapes of the default 100/75/100 network.
+*/
+func BenchmarkMul(b *testing.B) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, s := range [][3]int{{100, 75, 100}, {400, 175, 1}, {400, 175, 32}} {
+		n, k, d := s[0], s[1], s[2]
+		m1, dm1 := randFloats[float32](r, n*k), make([]float32, n*k)
+		m2, dm2 := randFloats[float32](r, k*d), make([]float32, k*d)
+		out, dout := make([]float32, n*d), randFloats[float32](r, n*d)
+		name := fmt.Sprintf("%dx%dx%d", n, k, d)
+		b.Run(name+"/naive", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				naiveMul(out, dout, m1, dm1, m2, dm2, n, k, d)
+			}
+		})
+		b.Run(name+"/blocked", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matMul(out, m1, m2, n, k, d, k)
+				matMulABtAdd(dm1, dout, m2, n, k, d, k)
+				matMulAtBAdd(dm2, m1, dout, n, k, d, k)
+			}
+		})
+	}
+}
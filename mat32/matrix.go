@@ -1,15 +1,38 @@
 package mat32
 
+import (
+	"math"
+	"math/rand/v2"
+)
+
+/*
+Float is the element type of a matrix. Training runs in float32; float64 is
+there for gradient checks and small research runs that want the precision.
+*/
+type Float interface {
+	~float32 | ~float64
+}
+
 /*
-Mat holds a matrix.
+MatOf holds a matrix of T.
 */
-type Mat struct {
+type MatOf[T Float] struct {
 	RowCount    int
 	ColumnCount int
-	W           []float32
-	DW          []float32
+	W           []T
+	DW          []T
 }
 
+/*
+Mat holds a float32 matrix.
+*/
+type Mat = MatOf[float32]
+
+/*
+Mat64 holds a float64 matrix.
+*/
+type Mat64 = MatOf[float64]
+
 //func (m *Mat) toJSON() (string, error) {
 //	b, err := json.Marshal(m)
 //
@@ -20,31 +43,138 @@ type Mat struct {
 //	return string(b[:]), err
 //}
 
-func zeros(size int) []float32 {
+func zeros[T Float](size int) []T {
 	// no need to initialize zero values
-	return make([]float32, size)
+	return make([]T, size)
 }
 
 /*
-NewMat instantiates a new matrix.
+NewMat instantiates a new float32 matrix.
 */
 func NewMat(n int, d int) *Mat {
-	m := Mat{RowCount: n, ColumnCount: d}
-	m.W = zeros(n * d)
-	m.DW = zeros(n * d)
+	return NewMatOf[float32](n, d)
+}
+
+/*
+NewMatOf instantiates a new matrix of T.
+*/
+func NewMatOf[T Float](n int, d int) *MatOf[T] {
+	m := MatOf[T]{RowCount: n, ColumnCount: d}
+	m.W = zeros[T](n * d)
+	m.DW = zeros[T](n * d)
 	return &m
 }
 
 /*
-RandMat fills a Mat with random numbers and returns it.
+RandMat fills a Mat with random numbers from r and returns it.
 */
-func RandMat(n int, d int, std float32) *Mat {
-	m := NewMat(n, d)
+func RandMat(r *rand.Rand, n int, d int, std float32) *Mat {
+	return RandMatOf(r, n, d, std)
+}
+
+/*
+RandMatOf fills a matrix of T with random numbers from r and returns it.
+*/
+func RandMatOf[T Float](r *rand.Rand, n int, d int, std T) *MatOf[T] {
+	m := NewMatOf[T](n, d)
 	last := len(m.W)
 
 	for i := 0; i < last; i++ {
-		m.W[i] = float32(Randf(-std, std))
+		m.W[i] = RandfOf(r, -std, std)
 	}
 
 	return m
 }
+
+/*
+ConvertMat copies m into a new matrix of another precision, W and DW both.
+*/
+func ConvertMat[To Float, From Float](m *MatOf[From]) *MatOf[To] {
+	out := NewMatOf[To](m.RowCount, m.ColumnCount)
+	for i := range m.W {
+		out.W[i] = To(m.W[i])
+		out.DW[i] = To(m.DW[i])
+	}
+	return out
+}
+
+/*
+Copy returns a copy of m, W and DW both, that shares nothing with it.
+*/
+func (m *MatOf[T]) Copy() *MatOf[T] {
+	out := NewMatOf[T](m.RowCount, m.ColumnCount)
+	copy(out.W, m.W)
+	copy(out.DW, m.DW)
+	return out
+}
+
+/*
+ApproxEqual tells whether m and other have the same shape and weights no more
+than tol apart.
+*/
+func (m *MatOf[T]) ApproxEqual(other *MatOf[T], tol T) bool {
+	if m.RowCount != other.RowCount || m.ColumnCount != other.ColumnCount {
+		return false
+	}
+	for i := range m.W {
+		diff := m.W[i] - other.W[i]
+		if diff > tol || diff < -tol || diff != diff { // diff != diff catches NaN
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Norm is the L2 norm of the weights of m.
+*/
+func (m *MatOf[T]) Norm() float64 {
+	return norm(m.W)
+}
+
+/*
+GradNorm is the L2 norm of the gradients of m.
+*/
+func (m *MatOf[T]) GradNorm() float64 {
+	return norm(m.DW)
+}
+
+func norm[T Float](w []T) float64 {
+	var sum float64
+	for _, v := range w {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
+
+/*
+Row is a view of row i of the weights: writing to it writes to m.
+*/
+func (m *MatOf[T]) Row(i int) []T {
+	Assert(i >= 0 && i < m.RowCount, "Row out of range")
+	return m.W[i*m.ColumnCount : (i+1)*m.ColumnCount : (i+1)*m.ColumnCount]
+}
+
+/*
+Column is a copy of column j of the weights. Columns are not contiguous, so
+unlike Row it cannot be a view; use SetColumn to write one back.
+*/
+func (m *MatOf[T]) Column(j int) []T {
+	Assert(j >= 0 && j < m.ColumnCount, "Column out of range")
+	col := make([]T, m.RowCount)
+	for i := range col {
+		col[i] = m.W[i*m.ColumnCount+j]
+	}
+	return col
+}
+
+/*
+SetColumn writes col into column j of the weights.
+*/
+func (m *MatOf[T]) SetColumn(j int, col []T) {
+	Assert(j >= 0 && j < m.ColumnCount, "SetColumn out of range")
+	Assert(len(col) == m.RowCount, "SetColumn needs one value per row")
+	for i, v := range col {
+		m.W[i*m.ColumnCount+j] = v
+	}
+}
@@ -0,0 +1,222 @@
+package mat32
+
+/*
+Sub subtracts m2 from m1.
+*/
+func (g *GraphOf[T]) Sub(m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
+	Assert(len(m1.W) == len(m2.W), "Cannot subtract arrays")
+	return g.binary(OpSub, m1, m2)
+}
+
+func forwardSub[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range m1.W {
+		out.W[i] = m1.W[i] - m2.W[i]
+	}
+}
+
+func backwardSub[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range out.DW {
+		m1.DW[i] += out.DW[i]
+		m2.DW[i] -= out.DW[i]
+	}
+}
+
+/*
+Scale multiplies every element of m by s.
+*/
+func (g *GraphOf[T]) Scale(m *MatOf[T], s T) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: OpScale, Output: g.NewMat(m.RowCount, m.ColumnCount), Inputs: g.keepMats(m), Scalar: s})
+}
+
+func forwardScale[T Float](op *TapeOp[T]) {
+	m, out, s := op.Inputs[0], op.Output, op.Scalar
+	for i := range m.W {
+		out.W[i] = m.W[i] * s
+	}
+}
+
+func backwardScale[T Float](op *TapeOp[T]) {
+	m, out, s := op.Inputs[0], op.Output, op.Scalar
+	for i := range out.DW {
+		m.DW[i] += s * out.DW[i]
+	}
+}
+
+/*
+Neg negates m.
+*/
+func (g *GraphOf[T]) Neg(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpNeg, m)
+}
+
+func forwardNeg[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range m.W {
+		out.W[i] = -m.W[i]
+	}
+}
+
+func backwardNeg[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range out.DW {
+		m.DW[i] -= out.DW[i]
+	}
+}
+
+/*
+Div divides m1 by m2, element-wise.
+*/
+func (g *GraphOf[T]) Div(m1 *MatOf[T], m2 *MatOf[T]) *MatOf[T] {
+	Assert(len(m1.W) == len(m2.W), "Cannot Div")
+	return g.binary(OpDiv, m1, m2)
+}
+
+func forwardDiv[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range m1.W {
+		out.W[i] = m1.W[i] / m2.W[i]
+	}
+}
+
+func backwardDiv[T Float](op *TapeOp[T]) {
+	m1, m2, out := op.Inputs[0], op.Inputs[1], op.Output
+	for i := range out.DW {
+		// d(a/b)/da = 1/b, d(a/b)/db = -a/b^2 = -out/b
+		m1.DW[i] += out.DW[i] / m2.W[i]
+		m2.DW[i] -= out.DW[i] * out.W[i] / m2.W[i]
+	}
+}
+
+/*
+reduce runs an op on m whose output is 1 x 1.
+*/
+func (g *GraphOf[T]) reduce(kind OpKind, m *MatOf[T]) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: kind, Output: g.NewMat(1, 1), Inputs: g.keepMats(m)})
+}
+
+/*
+Sum adds up every element of m into a 1 x 1 Mat.
+*/
+func (g *GraphOf[T]) Sum(m *MatOf[T]) *MatOf[T] {
+	return g.reduce(OpSum, m)
+}
+
+func forwardSum[T Float](op *TapeOp[T]) {
+	var sum T
+	for _, v := range op.Inputs[0].W {
+		sum += v
+	}
+	op.Output.W[0] = sum
+}
+
+func backwardSum[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range m.DW {
+		m.DW[i] += out.DW[0]
+	}
+}
+
+/*
+Mean averages every element of m into a 1 x 1 Mat.
+*/
+func (g *GraphOf[T]) Mean(m *MatOf[T]) *MatOf[T] {
+	Assert(len(m.W) > 0, "Mean of nothing")
+	return g.reduce(OpMean, m)
+}
+
+func forwardMean[T Float](op *TapeOp[T]) {
+	m := op.Inputs[0]
+	var sum T
+	for _, v := range m.W {
+		sum += v
+	}
+	op.Output.W[0] = sum / T(len(m.W))
+}
+
+func backwardMean[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := T(len(m.W))
+	for i := range m.DW {
+		m.DW[i] += out.DW[0] / n
+	}
+}
+
+/*
+Max is the largest element of m as a 1 x 1 Mat. The gradient goes to the
+first element holding the max.
+*/
+func (g *GraphOf[T]) Max(m *MatOf[T]) *MatOf[T] {
+	Assert(len(m.W) > 0, "Max of nothing")
+	return g.run(TapeOp[T]{Kind: OpMax, Output: g.NewMat(1, 1), Inputs: g.keepMats(m), Ints: g.keepInts(0)})
+}
+
+func forwardMax[T Float](op *TapeOp[T]) {
+	m := op.Inputs[0]
+	maxix := ArgmaxI(m.W)
+	op.Ints[0] = maxix
+	op.Output.W[0] = m.W[maxix]
+}
+
+func backwardMax[T Float](op *TapeOp[T]) {
+	op.Inputs[0].DW[op.Ints[0]] += op.Output.DW[0]
+}
+
+/*
+Transpose swaps the rows and columns of m.
+*/
+func (g *GraphOf[T]) Transpose(m *MatOf[T]) *MatOf[T] {
+	return g.run(TapeOp[T]{Kind: OpTranspose, Output: g.NewMat(m.ColumnCount, m.RowCount), Inputs: g.keepMats(m)})
+}
+
+func forwardTranspose[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n, d := m.RowCount, m.ColumnCount
+	for i := 0; i < n; i++ {
+		for j := 0; j < d; j++ {
+			out.W[j*n+i] = m.W[i*d+j]
+		}
+	}
+}
+
+func backwardTranspose[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n, d := m.RowCount, m.ColumnCount
+	for i := 0; i < n; i++ {
+		for j := 0; j < d; j++ {
+			m.DW[i*d+j] += out.DW[j*n+i]
+		}
+	}
+}
+
+/*
+Reshape is m with its elements, in order, laid out as n x d.
+*/
+func (g *GraphOf[T]) Reshape(m *MatOf[T], n int, d int) *MatOf[T] {
+	Assert(n*d == len(m.W), "Reshape must keep the number of elements")
+	return g.run(TapeOp[T]{Kind: OpReshape, Output: g.NewMat(n, d), Inputs: g.keepMats(m)})
+}
+
+/*
+Clone is a copy of m that passes its gradient back to m, for using the same
+values in two places of a graph under different names.
+*/
+func (g *GraphOf[T]) Clone(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpClone, m)
+}
+
+/*
+forwardCopy is the forward pass of Reshape and Clone, which only differ in
+the shape of their output.
+*/
+func forwardCopy[T Float](op *TapeOp[T]) {
+	copy(op.Output.W, op.Inputs[0].W)
+}
+
+func backwardCopy[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	for i := range out.DW {
+		m.DW[i] += out.DW[i]
+	}
+}
@@ -0,0 +1,160 @@
+package mat32
+
+import "math"
+
+/*
+QuantMat is a matrix quantized to int8 for inference, with a scale per row:
+row i is Scale[i] times its int8 values. Each row is scaled so its biggest
+weight is 127, so a row of small weights keeps its precision next to a row
+of big ones.
+
+Q is the int8 values row by row, stored as bytes so JSON has them in base64.
+*/
+type QuantMat struct {
+	RowCount    int
+	ColumnCount int
+	Scale       []float32
+	Q           []byte
+}
+
+/*
+Quantize rounds the weights of m to int8, a scale per row.
+*/
+func Quantize[T Float](m *MatOf[T]) *QuantMat {
+	n := m.RowCount
+	d := m.ColumnCount
+	q := &QuantMat{
+		RowCount:    n,
+		ColumnCount: d,
+		Scale:       make([]float32, n),
+		Q:           make([]byte, n*d),
+	}
+	for r := 0; r < n; r++ {
+		row := m.W[r*d : (r+1)*d]
+		var maxabs float64
+		for _, v := range row {
+			maxabs = math.Max(maxabs, math.Abs(float64(v)))
+		}
+		if maxabs == 0 {
+			continue // all zeros, and so is the scale
+		}
+		scale := maxabs / 127
+		q.Scale[r] = float32(scale)
+		for j, v := range row {
+			qv := math.Max(-127, math.Min(127, math.Round(float64(v)/scale)))
+			q.Q[r*d+j] = byte(int8(qv))
+		}
+	}
+	return q
+}
+
+/*
+Dequantize expands q back into a Mat of T.
+*/
+func Dequantize[T Float](q *QuantMat) *MatOf[T] {
+	m := NewMatOf[T](q.RowCount, q.ColumnCount)
+	d := q.ColumnCount
+	for i, b := range q.Q {
+		m.W[i] = T(q.Scale[i/d]) * T(int8(b))
+	}
+	return m
+}
+
+/*
+quantMatMul sets out to rows [from, to) and columns [colFrom, colTo) of q
+times x ((colTo-colFrom) x batch). For a single column this is an int8 matrix-vector product: each row is a
+dot product of int8 weights with x, scaled once at the end.
+*/
+func quantMatMul[T Float](out []T, q *QuantMat, from int, to int, colFrom int, colTo int, x []T, batch int) {
+	k := q.ColumnCount
+	rows := func(rfrom int, rto int) {
+		acc := make([]T, batch)
+		for r := from + rfrom; r < from+rto; r++ {
+			row := q.Q[r*k+colFrom : r*k+colTo]
+			o := out[(r-from)*batch : (r-from+1)*batch]
+			if batch == 1 {
+				o[0] = T(q.Scale[r]) * dotInt8(row, x)
+				continue
+			}
+			clear(acc)
+			for j, b := range row {
+				if b == 0 {
+					continue
+				}
+				axpy(T(int8(b)), x[j*batch:(j+1)*batch], acc)
+			}
+			for c := range o {
+				o[c] = T(q.Scale[r]) * acc[c]
+			}
+		}
+	}
+	if !parallel(to-from, (to-from)*(colTo-colFrom)*batch) {
+		rows(0, to-from)
+		return
+	}
+	parallelRows(to-from, rows)
+}
+
+/*
+dotInt8 is the dot product of int8 weights, as bytes, with x.
+*/
+func dotInt8[T Float](q []byte, x []T) T {
+	x = x[:len(q)]
+	var s0, s1, s2, s3 T
+	i := 0
+	for ; i+4 <= len(q); i += 4 {
+		s0 += T(int8(q[i])) * x[i]
+		s1 += T(int8(q[i+1])) * x[i+1]
+		s2 += T(int8(q[i+2])) * x[i+2]
+		s3 += T(int8(q[i+3])) * x[i+3]
+	}
+	for ; i < len(q); i++ {
+		s0 += T(int8(q[i])) * x[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+/*
+QuantMul multiplies the int8 matrix q by m. It is for inference only, so it
+has no backprop.
+*/
+func (g *GraphOf[T]) QuantMul(q *QuantMat, m *MatOf[T]) *MatOf[T] {
+	Assert(!g.NeedsBackprop, "QuantMul has no backprop")
+	Assert(q.ColumnCount == m.RowCount, "matmul dimensions misaligned")
+	out := g.NewMat(q.RowCount, m.ColumnCount)
+	quantMatMul(out.W, q, 0, q.RowCount, 0, q.ColumnCount, m.W, m.ColumnCount)
+	return out
+}
+
+/*
+QuantMulBlock is MulBlock for the int8 matrix q. It is for inference only, so
+it has no backprop.
+*/
+func (g *GraphOf[T]) QuantMulBlock(q *QuantMat, rowFrom int, rowTo int, colFrom int, colTo int, m *MatOf[T]) *MatOf[T] {
+	Assert(!g.NeedsBackprop, "QuantMulBlock has no backprop")
+	Assert(rowFrom >= 0 && rowFrom < rowTo && rowTo <= q.RowCount, "QuantMulBlock invalid rows")
+	Assert(colFrom >= 0 && colFrom < colTo && colTo <= q.ColumnCount, "QuantMulBlock invalid columns")
+	Assert(colTo-colFrom == m.RowCount, "matmul dimensions misaligned")
+	out := g.NewMat(rowTo-rowFrom, m.ColumnCount)
+	quantMatMul(out.W, q, rowFrom, rowTo, colFrom, colTo, m.W, m.ColumnCount)
+	return out
+}
+
+/*
+QuantRowsPluck is RowsPluck from an int8 matrix, like a quantized embedding
+table. It is for inference only, so it has no backprop.
+*/
+func (g *GraphOf[T]) QuantRowsPluck(q *QuantMat, ixs []int) *MatOf[T] {
+	Assert(!g.NeedsBackprop, "QuantRowsPluck has no backprop")
+	d := q.ColumnCount
+	b := len(ixs)
+	out := g.NewMat(d, b)
+	for col, ix := range ixs {
+		Assert(ix >= 0 && ix < q.RowCount, "QuantRowsPluck invalid number of rows")
+		scale := T(q.Scale[ix])
+		for i, v := range q.Q[ix*d : (ix+1)*d] {
+			out.W[i*b+col] = scale * T(int8(v))
+		}
+	}
+	return out
+}
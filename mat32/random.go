@@ -1,12 +1,26 @@
 package mat32
 
 import (
-	"math/rand"
+	"math/rand/v2"
 )
 
 /*
-Randf makes random numbers
+Randf makes random numbers from r
 */
-func Randf(a float32, b float32) float32 {
-	return rand.Float32()*(b-a) + a
+func Randf(r *rand.Rand, a float32, b float32) float32 {
+	return r.Float32()*(b-a) + a
+}
+
+/*
+RandfOf makes random numbers of T from r
+*/
+func RandfOf[T Float](r *rand.Rand, a T, b T) T {
+	var f T
+	switch any(f).(type) {
+	case float32:
+		f = T(r.Float32())
+	default:
+		f = T(r.Float64())
+	}
+	return f*(b-a) + a
 }
@@ -0,0 +1,204 @@
+package mat32
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+/*
+Sampler says how to pick the next index from a column of logits. A Sampler
+with a Temperature of 1 and nothing else samples straight from their softmax.
+The filters run in the order of the fields: temperature, then top-k, then
+top-p, then typical.
+*/
+type Sampler struct {
+	Temperature float64 // divides the logits; below 1 is more conservative, above 1 wilder. 0 is greedy, the limit of going lower
+	TopK        int     // only the K most likely indexes can be picked, 0 for all
+	TopP        float64 // only the fewest most likely indexes holding P of the probability (nucleus sampling), 0 for all
+	Typical     float64 // only the indexes closest to the expected surprise, holding this much of the probability, 0 for all
+	Greedy      bool    // always the most likely index, no sampling at all
+}
+
+/*
+Validate tells what is wrong with s, if anything.
+*/
+func (s Sampler) Validate() error {
+	if s.Temperature < 0 {
+		return fmt.Errorf("sampling temperature %v must be 0 or more", s.Temperature)
+	}
+	if s.TopK < 0 {
+		return fmt.Errorf("sampling top-k %v must be 0 or more", s.TopK)
+	}
+	if s.TopP < 0 || s.TopP > 1 {
+		return fmt.Errorf("sampling top-p %v must be between 0 and 1", s.TopP)
+	}
+	if s.Typical < 0 || s.Typical > 1 {
+		return fmt.Errorf("typical sampling mass %v must be between 0 and 1", s.Typical)
+	}
+	if s.greedy() && (s.TopK > 0 || s.TopP > 0 || s.Typical > 0) {
+		return fmt.Errorf("greedy sampling, which a temperature of 0 is, cannot also filter by top-k, top-p or typical")
+	}
+	return nil
+}
+
+/*
+greedy tells whether s always takes the most likely index.
+*/
+func (s Sampler) greedy() bool {
+	return s.Greedy || s.Temperature == 0
+}
+
+/*
+Probabilities is the distribution s samples logits from, with everything
+the filters cut at zero. Greedy, it is all on the most likely index.
+*/
+func (s Sampler) Probabilities(logits []float64) []float64 {
+	if s.greedy() {
+		probs := make([]float64, len(logits))
+		probs[ArgmaxI(logits)] = 1
+		return probs
+	}
+	probs := SoftmaxTemperature(logits, s.Temperature)
+	if s.TopK > 0 {
+		TopK(probs, s.TopK)
+	}
+	if s.TopP > 0 {
+		TopP(probs, s.TopP)
+	}
+	if s.Typical > 0 {
+		Typical(probs, s.Typical)
+	}
+	return probs
+}
+
+/*
+Sample picks an index of logits, which are unnormalized log probabilities
+like the output of a network, the way s says to.
+*/
+func Sample[T Float](rng *rand.Rand, s Sampler, logits []T) int {
+	if s.greedy() {
+		return ArgmaxI(logits)
+	}
+	l := make([]float64, len(logits))
+	for i, v := range logits {
+		l[i] = float64(v)
+	}
+	return SampleArgmaxI(rng, s.Probabilities(l))
+}
+
+/*
+SoftmaxTemperature is the softmax of logits divided by temperature.
+*/
+func SoftmaxTemperature(logits []float64, temperature float64) []float64 {
+	Assert(temperature > 0, "temperature must be positive")
+	probs := make([]float64, len(logits))
+	maxval := math.Inf(-1)
+	for _, v := range logits {
+		maxval = math.Max(maxval, v)
+	}
+	var sum float64
+	for i, v := range logits {
+		probs[i] = math.Exp((v - maxval) / temperature)
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
+
+/*
+byProbability is the indexes of probs, most likely first. Ties keep their
+index order, so the same probs always sort the same way.
+*/
+func byProbability(probs []float64) []int {
+	order := make([]int, len(probs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return probs[order[a]] > probs[order[b]] })
+	return order
+}
+
+/*
+keepOnly zeroes every probability but the ones at keep and renormalizes.
+*/
+func keepOnly(probs []float64, keep []int) {
+	kept := make([]float64, len(probs))
+	var sum float64
+	for _, i := range keep {
+		kept[i] = probs[i]
+		sum += probs[i]
+	}
+	if sum <= 0 {
+		return // nothing left worth keeping, leave probs be
+	}
+	for i := range probs {
+		probs[i] = kept[i] / sum
+	}
+}
+
+/*
+TopK keeps the k most likely of probs, renormalized, in place.
+*/
+func TopK(probs []float64, k int) {
+	if k <= 0 || k >= len(probs) {
+		return
+	}
+	keepOnly(probs, byProbability(probs)[:k])
+}
+
+/*
+TopP keeps the fewest most likely of probs that add up to at least p,
+renormalized, in place. This is nucleus sampling (Holtzman et al., 2019).
+*/
+func TopP(probs []float64, p float64) {
+	if p <= 0 || p >= 1 {
+		return
+	}
+	order := byProbability(probs)
+	var sum float64
+	n := 0
+	for n < len(order) && sum < p {
+		sum += probs[order[n]]
+		n++
+	}
+	keepOnly(probs, order[:n])
+}
+
+/*
+Typical keeps the indexes whose surprise, -log p, is closest to the entropy
+of probs - the expected surprise - until they add up to at least mass,
+renormalized, in place. This is locally typical sampling (Meister et al.,
+2022): it drops the very likely as well as the very unlikely.
+*/
+func Typical(probs []float64, mass float64) {
+	if mass <= 0 || mass >= 1 {
+		return
+	}
+	var entropy float64
+	for _, p := range probs {
+		if p > 0 {
+			entropy -= p * math.Log(p)
+		}
+	}
+	order := make([]int, 0, len(probs))
+	distance := make([]float64, len(probs))
+	for i, p := range probs {
+		if p > 0 {
+			distance[i] = math.Abs(-math.Log(p) - entropy)
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return distance[order[a]] < distance[order[b]] })
+
+	var sum float64
+	n := 0
+	for n < len(order) && sum < mass {
+		sum += probs[order[n]]
+		n++
+	}
+	keepOnly(probs, order[:n])
+}
@@ -0,0 +1,122 @@
+package mat32
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestSamplerValidate(t *testing.T) {
+	for _, s := range []Sampler{
+		{Temperature: -1},
+		{Temperature: 1, TopK: -1},
+		{Temperature: 1, TopP: 1.5},
+		{Temperature: 1, Typical: -0.1},
+		{Temperature: 1, Greedy: true, TopK: 3},
+		{Temperature: 1, Greedy: true, TopP: 0.9},
+		{Temperature: 1, Greedy: true, Typical: 0.9},
+		{TopK: 5},
+		{TopP: 0.9},
+		{Typical: 0.9},
+	} {
+		if s.Validate() == nil {
+			t.Errorf("%+v is valid, want an error", s)
+		}
+	}
+	for _, s := range []Sampler{
+		{},
+		{Temperature: 1},
+		{Temperature: 0.5, TopK: 3, TopP: 0.9, Typical: 0.9},
+		{Temperature: 1, Greedy: true},
+	} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("%+v: %v", s, err)
+		}
+	}
+}
+
+/*
+TestSampleTemperatureZero checks a temperature of 0 is greedy, as the limit
+of lowering it is.
+*/
+func TestSampleTemperatureZero(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	logits := []float32{0.1, 2, 1.9, -3}
+	for _, s := range []Sampler{{}, {Greedy: true, Temperature: 1}} {
+		for i := 0; i < 100; i++ {
+			if got := Sample(r, s, logits); got != 1 {
+				t.Fatalf("%+v picked %d, want 1", s, got)
+			}
+		}
+		probs := s.Probabilities([]float64{0.1, 2, 1.9, -3})
+		if probs[1] != 1 {
+			t.Errorf("%+v: probabilities are %v, want all on 1", s, probs)
+		}
+	}
+
+	// a temperature of 1 still samples the others
+	seen := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		seen[Sample(r, Sampler{Temperature: 1}, logits)] = true
+	}
+	if !seen[2] {
+		t.Errorf("temperature 1 never picked 2, which is about as likely as 1")
+	}
+}
+
+func checkProbs(t *testing.T, name string, got []float64, want []float64) {
+	t.Helper()
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+/*
+TestFilters checks the exact distributions TopK, TopP and Typical leave,
+ties included: they keep the lower index.
+*/
+func TestFilters(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		filter func(probs []float64)
+		probs  []float64
+		want   []float64
+	}{
+		{"TopK 2", func(p []float64) { TopK(p, 2) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0, 4.0 / 7, 0, 3.0 / 7}},
+		{"TopK 0", func(p []float64) { TopK(p, 0) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0.1, 0.4, 0.2, 0.3}},
+		{"TopK all", func(p []float64) { TopK(p, 4) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0.1, 0.4, 0.2, 0.3}},
+		{"TopK tie 1", func(p []float64) { TopK(p, 1) }, []float64{0.3, 0.2, 0.3, 0.2}, []float64{1, 0, 0, 0}},
+		{"TopK tie 3", func(p []float64) { TopK(p, 3) }, []float64{0.3, 0.2, 0.3, 0.2}, []float64{0.375, 0.25, 0.375, 0}},
+
+		// below the largest probability, only it is left
+		{"TopP below the top", func(p []float64) { TopP(p, 0.3) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0, 1, 0, 0}},
+		{"TopP 0.65", func(p []float64) { TopP(p, 0.65) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0, 4.0 / 7, 0, 3.0 / 7}},
+		{"TopP 0.75", func(p []float64) { TopP(p, 0.75) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0, 4.0 / 9, 2.0 / 9, 3.0 / 9}},
+		{"TopP 1", func(p []float64) { TopP(p, 1) }, []float64{0.1, 0.4, 0.2, 0.3}, []float64{0.1, 0.4, 0.2, 0.3}},
+		{"TopP tie", func(p []float64) { TopP(p, 0.5) }, []float64{0.25, 0.25, 0.25, 0.25}, []float64{0.5, 0.5, 0, 0}},
+
+		// surprises of 1, 2, 3 and 3 bits around an entropy of 1.75 bits
+		{"Typical 0.2", func(p []float64) { Typical(p, 0.2) }, []float64{0.5, 0.25, 0.125, 0.125}, []float64{0, 1, 0, 0}},
+		{"Typical 0.5", func(p []float64) { Typical(p, 0.5) }, []float64{0.5, 0.25, 0.125, 0.125}, []float64{2.0 / 3, 1.0 / 3, 0, 0}},
+		{"Typical tie", func(p []float64) { Typical(p, 0.8) }, []float64{0.5, 0.25, 0.125, 0.125}, []float64{4.0 / 7, 2.0 / 7, 1.0 / 7, 0}},
+		{"Typical 1", func(p []float64) { Typical(p, 1) }, []float64{0.5, 0.25, 0.125, 0.125}, []float64{0.5, 0.25, 0.125, 0.125}},
+	} {
+		probs := append([]float64(nil), c.probs...)
+		c.filter(probs)
+		checkProbs(t, c.name, probs, c.want)
+	}
+}
+
+/*
+TestProbabilitiesOrder checks the filters of a Sampler run one after the
+other, on the tempered softmax.
+*/
+func TestProbabilitiesOrder(t *testing.T) {
+	logits := []float64{math.Log(0.1), math.Log(0.4), math.Log(0.2), math.Log(0.3)}
+	got := Sampler{Temperature: 1, TopK: 3, TopP: 0.5}.Probabilities(logits)
+	// top-k leaves 4/9, 2/9 and 3/9, and 4/9 + 3/9 is the first past 0.5
+	checkProbs(t, "TopK then TopP", got, []float64{0, 4.0 / 7, 0, 3.0 / 7})
+}
@@ -2,39 +2,187 @@ package mat32
 
 import (
 	"math"
+	"math/rand/v2"
 )
 
 /*
-Softmax computes the softmax of a matrix, I guess.
+Softmax computes the softmax of a matrix, I guess. Each column is its own
+distribution, so a batch of columns gets a batch of softmaxes.
 */
-func Softmax(m *Mat) *Mat {
-	out := NewMat(m.RowCount, m.ColumnCount) // probability volume
-	var maxval float32 = -999999.0
-	i := 0
-	n := len(m.W)
+func Softmax[T Float](m *MatOf[T]) *MatOf[T] {
+	out := NewMatOf[T](m.RowCount, m.ColumnCount) // probability volume
+	n := m.RowCount
+	b := m.ColumnCount
 
-	for ; i < n; i++ {
-		if m.W[i] > maxval {
-			maxval = m.W[i]
+	for col := 0; col < b; col++ {
+		var maxval T = -999999.0
+		for i := col; i < n*b; i += b {
+			if m.W[i] > maxval {
+				maxval = m.W[i]
+			}
+		}
+
+		var s T = 0.0
+		for i := col; i < n*b; i += b {
+			out.W[i] = T(math.Exp(float64(m.W[i]) - float64(maxval)))
+			s += out.W[i]
+		}
+
+		for i := col; i < n*b; i += b {
+			out.W[i] /= s
 		}
 	}
 
-	var s float32 = 0.0
-	i = 0
-	for ; i < n; i++ {
-		out.W[i] = float32(math.Exp(float64(m.W[i]) - float64(maxval)))
-		s += out.W[i]
+	// no backward pass here, use Graph.Softmax when gradients are needed
+	return out
+}
+
+/*
+logSumExp returns log(sum(exp(x))) of column col of m, shifted by the
+column max so exp cannot overflow.
+*/
+func logSumExp[T Float](m *MatOf[T], col int) float64 {
+	n := m.RowCount
+	b := m.ColumnCount
+	maxval := math.Inf(-1)
+	for i := col; i < n*b; i += b {
+		maxval = math.Max(maxval, float64(m.W[i]))
+	}
+	var s float64
+	for i := col; i < n*b; i += b {
+		s += math.Exp(float64(m.W[i]) - maxval)
 	}
+	return maxval + math.Log(s)
+}
 
-	i = 0
-	for ; i < n; i++ {
-		out.W[i] /= s
+/*
+Softmax is a softmax over each column of m, with backprop.
+*/
+func (g *GraphOf[T]) Softmax(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpSoftmax, m)
+}
+
+func forwardSoftmax[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := m.RowCount
+	b := m.ColumnCount
+	for col := 0; col < b; col++ {
+		lse := logSumExp(m, col)
+		for i := col; i < n*b; i += b {
+			out.W[i] = T(math.Exp(float64(m.W[i]) - lse))
+		}
 	}
+}
 
-	// no backward pass here needed
-	// since we will use the computed probabilities outside
-	// to set gradients directly on m
-	return out
+func backwardSoftmax[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := m.RowCount
+	b := m.ColumnCount
+	// dx_i = y_i * (dy_i - sum_j(y_j * dy_j))
+	for col := 0; col < b; col++ {
+		var dotYDY T
+		for i := col; i < n*b; i += b {
+			dotYDY += out.W[i] * out.DW[i]
+		}
+		for i := col; i < n*b; i += b {
+			m.DW[i] += out.W[i] * (out.DW[i] - dotYDY)
+		}
+	}
+}
+
+/*
+LogSoftmax is the log of a softmax over each column of m, computed without
+ever taking the log of a tiny probability.
+*/
+func (g *GraphOf[T]) LogSoftmax(m *MatOf[T]) *MatOf[T] {
+	return g.unary(OpLogSoftmax, m)
+}
+
+func forwardLogSoftmax[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := m.RowCount
+	b := m.ColumnCount
+	for col := 0; col < b; col++ {
+		lse := logSumExp(m, col)
+		for i := col; i < n*b; i += b {
+			out.W[i] = T(float64(m.W[i]) - lse)
+		}
+	}
+}
+
+func backwardLogSoftmax[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := m.RowCount
+	b := m.ColumnCount
+	// dx_i = dy_i - softmax_i * sum_j(dy_j)
+	for col := 0; col < b; col++ {
+		var sumDY T
+		for i := col; i < n*b; i += b {
+			sumDY += out.DW[i]
+		}
+		for i := col; i < n*b; i += b {
+			m.DW[i] += out.DW[i] - T(math.Exp(float64(out.W[i])))*sumDY
+		}
+	}
+}
+
+/*
+SoftmaxCrossEntropy is the cross entropy loss of a softmax over each column
+of m against the target row of that column, -log(softmax(m)[target]),
+returned as a 1 x columns Mat. A negative target masks its column out: its
+loss is zero and it gets no gradient.
+
+To backprop, set the DW of the result to the weight of each column's loss -
+1 for a sum, 1/columns for a mean.
+*/
+func (g *GraphOf[T]) SoftmaxCrossEntropy(m *MatOf[T], targets []int) *MatOf[T] {
+	Assert(len(targets) == m.ColumnCount, "SoftmaxCrossEntropy needs one target per column")
+	for _, t := range targets {
+		Assert(t < m.RowCount, "SoftmaxCrossEntropy target out of range")
+	}
+	targets = g.keepInts(targets...) // the caller may reuse its slice before Backward
+	var saved [][]T
+	if g.NeedsBackprop {
+		saved = g.keepSaved(g.floats(len(m.W))) // the softmax, for backprop
+	}
+	return g.run(TapeOp[T]{Kind: OpSoftmaxCrossEntropy, Output: g.NewMat(1, m.ColumnCount), Inputs: g.keepMats(m), Ints: targets, Saved: saved})
+}
+
+func forwardSoftmaxCrossEntropy[T Float](op *TapeOp[T]) {
+	m, out := op.Inputs[0], op.Output
+	n := m.RowCount
+	b := m.ColumnCount
+	for col, t := range op.Ints {
+		out.W[col] = 0
+		if t < 0 {
+			continue
+		}
+		lse := logSumExp(m, col)
+		out.W[col] = T(lse - float64(m.W[t*b+col]))
+		if op.Saved != nil {
+			probs := op.Saved[0]
+			for i := col; i < n*b; i += b {
+				probs[i] = T(math.Exp(float64(m.W[i]) - lse))
+			}
+		}
+	}
+}
+
+func backwardSoftmaxCrossEntropy[T Float](op *TapeOp[T]) {
+	m, out, probs := op.Inputs[0], op.Output, op.Saved[0]
+	n := m.RowCount
+	b := m.ColumnCount
+	// dx = dloss * (softmax - onehot(target))
+	for col, t := range op.Ints {
+		if t < 0 {
+			continue
+		}
+		dloss := out.DW[col]
+		for i := col; i < n*b; i += b {
+			m.DW[i] += dloss * probs[i]
+		}
+		m.DW[t*b+col] -= dloss
+	}
 }
 
 /*
@@ -42,7 +190,7 @@ ArgmaxI does something with max and i. maybe integer.
 
 Old comment: argmax of array w
 */
-func ArgmaxI(w []float32) int {
+func ArgmaxI[T Float](w []T) int {
 	maxv := w[0]
 	maxix := 0
 	i := 1
@@ -61,18 +209,29 @@ func ArgmaxI(w []float32) int {
 SampleArgmaxI does something with sampling and integers, maybe.
 
 Old comment: sample argmax from w, assuming w are probabilities that sum to one
-*/
-func SampleArgmaxI(w []float32) int {
-	r := Randf(0, 1)
-	var x float32 = 0.0
-	i := 0
 
-	for {
-		x += w[i]
+They need not sum to exactly one - w is scaled by its sum - and a draw that
+rounding carries past the end picks the last index that has any probability.
+*/
+func SampleArgmaxI[T Float](rng *rand.Rand, w []T) int {
+	var total T
+	for _, v := range w {
+		total += v
+	}
+	if !(total > 0) { // no probabilities at all, or NaN
+		return ArgmaxI(w)
+	}
+	r := RandfOf[T](rng, 0, 1) * total
+	var x T = 0.0
+	last := 0
+	for i, v := range w {
+		x += v
 		if x > r {
 			return i
 		}
-		i++
+		if v > 0 {
+			last = i
+		}
 	}
-	return len(w) - 1
+	return last
 }
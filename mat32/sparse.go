@@ -0,0 +1,83 @@
+package mat32
+
+import "sort"
+
+/*
+touchRows records that rows of m are getting a gradient from a pluck.
+*/
+func (g *GraphOf[T]) touchRows(m *MatOf[T], rows ...int) {
+	g.bpMux.Lock()
+	if g.touchedRows == nil {
+		g.touchedRows = make(map[*MatOf[T]]map[int]bool)
+	}
+	if g.touchedRows[m] == nil {
+		// kept when cleared, empty, so the next step does not make it again
+		g.touchedRows[m] = make(map[int]bool)
+	}
+	for _, r := range rows {
+		g.touchedRows[m][r] = true
+	}
+	g.bpMux.Unlock()
+}
+
+/*
+useDensely records that the inputs of op, which is about to go on the tape,
+get a gradient other than through a pluck. Call it with bpMux held.
+*/
+func (g *GraphOf[T]) useDensely(op *TapeOp[T]) {
+	switch op.Kind {
+	case OpRowPluck, OpRowsPluck:
+		return
+	case OpCheckpoint:
+		// the segment's ops went on the segment tape, not this one, but
+		// they were recorded here all the same: segment.run builds them on
+		// g with only the tape swapped, so denseInputs is g's. Building a
+		// segment on another graph would lose that.
+		return
+	}
+	if g.denseInputs == nil {
+		g.denseInputs = make(map[*MatOf[T]]bool)
+	}
+	for _, m := range op.Inputs {
+		g.denseInputs[m] = true
+	}
+}
+
+/*
+TouchedRows returns, sorted, the rows of m that RowPluck or RowsPluck have
+given a gradient since the last ClearTouchedRows. ok is false when m was
+not plucked since, or was also read by some other op, since then any of its
+rows can have a gradient and it needs a dense update.
+
+For an embedding table - a Mat that is only ever read by plucking rows -
+these are the only rows whose DW can be nonzero, so an optimizer can skip
+the rest.
+*/
+func (g *GraphOf[T]) TouchedRows(m *MatOf[T]) (rows []int, ok bool) {
+	g.bpMux.Lock()
+	defer g.bpMux.Unlock()
+	touched := g.touchedRows[m]
+	if len(touched) == 0 || g.denseInputs[m] {
+		return nil, false
+	}
+	rows = make([]int, 0, len(touched))
+	for r := range touched {
+		rows = append(rows, r)
+	}
+	sort.Ints(rows)
+	return rows, true
+}
+
+/*
+ClearTouchedRows forgets the touched rows, and which Mats were read some
+other way. They build up across graphs until this is called, so an
+optimizer should call it once it has applied the gradients.
+*/
+func (g *GraphOf[T]) ClearTouchedRows() {
+	g.bpMux.Lock()
+	for _, rows := range g.touchedRows {
+		clear(rows)
+	}
+	clear(g.denseInputs)
+	g.bpMux.Unlock()
+}
@@ -0,0 +1,33 @@
+package mat32
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTouchedRows(t *testing.T) {
+	g := &Graph{}
+	g.ResetBackprop(true)
+	emb := NewMat(5, 3)
+	g.RowPluck(emb, 3)
+	g.RowsPluck(emb, []int{1, 3})
+	rows, ok := g.TouchedRows(emb)
+	if !ok || !slices.Equal(rows, []int{1, 3}) {
+		t.Fatalf("touched rows are %v %v, want [1 3] true", rows, ok)
+	}
+
+	// read whole, any row can get a gradient
+	b := g.Branch()
+	b.Mul(NewMat(2, 5), emb)
+	g.Merge(b)
+	if rows, ok := g.TouchedRows(emb); ok {
+		t.Fatalf("a Mat that was multiplied got sparse rows %v", rows)
+	}
+
+	g.ClearTouchedRows()
+	g.ResetBackprop(true)
+	g.RowPluck(emb, 0)
+	if rows, ok := g.TouchedRows(emb); !ok || !slices.Equal(rows, []int{0}) {
+		t.Fatalf("touched rows after clearing are %v %v, want [0] true", rows, ok)
+	}
+}
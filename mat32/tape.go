@@ -0,0 +1,382 @@
+package mat32
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+)
+
+/*
+OpKind is what kind of op a TapeOp is.
+*/
+type OpKind uint8
+
+const (
+	OpFunc OpKind = iota // an opaque backprop function from AddBackprop
+	OpRowPluck
+	OpRowsPluck
+	OpTanh
+	OpSigmoid
+	OpRelu
+	OpMul
+	OpMulBlock
+	OpAdd
+	OpAddBroadcast
+	OpEltmul
+	OpSub
+	OpScale
+	OpNeg
+	OpDiv
+	OpSum
+	OpMean
+	OpMax
+	OpTranspose
+	OpReshape
+	OpClone
+	OpConcatRows
+	OpConcatColumns
+	OpSliceRows
+	OpSliceColumns
+	OpGelu
+	OpLeakyRelu
+	OpPRelu
+	OpElu
+	OpSoftplus
+	OpSwish
+	OpHardSigmoid
+	OpHardTanh
+	OpLayerNorm
+	OpDropout
+	OpSoftmax
+	OpLogSoftmax
+	OpSoftmaxCrossEntropy
+	OpLSTMCell
+	OpSimplifiedLSTMCell
+	OpCheckpoint
+	numOpKinds
+)
+
+var opNames = [numOpKinds]string{
+	"Func", "RowPluck", "RowsPluck", "Tanh", "Sigmoid", "Relu", "Mul", "MulBlock",
+	"Add", "AddBroadcast", "Eltmul", "Sub", "Scale", "Neg", "Div", "Sum",
+	"Mean", "Max", "Transpose", "Reshape", "Clone", "ConcatRows", "ConcatColumns",
+	"SliceRows", "SliceColumns", "Gelu", "LeakyRelu", "PRelu", "Elu",
+	"Softplus", "Swish", "HardSigmoid", "HardTanh", "LayerNorm", "Dropout",
+	"Softmax", "LogSoftmax", "SoftmaxCrossEntropy", "LSTMCell",
+	"SimplifiedLSTMCell", "Checkpoint",
+}
+
+func (k OpKind) String() string {
+	if k < numOpKinds {
+		return opNames[k]
+	}
+	return fmt.Sprintf("OpKind(%d)", k)
+}
+
+/*
+TapeOp is one op on a Graph's tape: what it was, what it read and wrote, and
+what its forward pass kept for the backward one. Backward and Replay
+dispatch on the Kind, so a tape can be looked at, counted and run again.
+*/
+type TapeOp[T Float] struct {
+	Kind   OpKind
+	Output *MatOf[T]
+	Extra  []*MatOf[T] // outputs after the first, for ops that have more
+	Inputs []*MatOf[T]
+	Saved  [][]T  // buffers the forward pass kept for the backward one
+	Ints   []int  // integer arguments, like plucked rows or slice bounds
+	Scalar T      // a scalar argument, like Scale's factor
+	fn     func() // the backprop of an OpFunc
+
+	segment *segment[T] // what an OpCheckpoint recomputes
+}
+
+/*
+Bytes is the memory the op holds on to until the graph is reset: the values
+and gradients of its outputs, and what it saved for backprop.
+*/
+func (op *TapeOp[T]) Bytes() int {
+	var zero T
+	floats := 0
+	if op.Output != nil {
+		floats += len(op.Output.W) + len(op.Output.DW)
+	}
+	for _, m := range op.Extra {
+		floats += len(m.W) + len(m.DW)
+	}
+	for _, s := range op.Saved {
+		floats += len(s)
+	}
+	return floats*int(unsafe.Sizeof(zero)) + len(op.Ints)*int(unsafe.Sizeof(0))
+}
+
+/*
+mats calls f on every Mat whose DW the op's backprop reads or writes.
+*/
+func (op *TapeOp[T]) mats(f func(m *MatOf[T])) {
+	if op.Output != nil {
+		f(op.Output)
+	}
+	for _, m := range op.Extra {
+		f(m)
+	}
+	for _, m := range op.Inputs {
+		f(m)
+	}
+}
+
+/*
+forward computes the op's outputs from its inputs.
+*/
+func (op *TapeOp[T]) forward() {
+	switch op.Kind {
+	case OpRowPluck:
+		forwardRowPluck(op)
+	case OpRowsPluck:
+		forwardRowsPluck(op)
+	case OpTanh:
+		forwardTanh(op)
+	case OpSigmoid:
+		forwardSigmoid(op)
+	case OpRelu:
+		forwardRelu(op)
+	case OpMul:
+		forwardMul(op)
+	case OpMulBlock:
+		forwardMulBlock(op)
+	case OpAdd:
+		forwardAdd(op)
+	case OpAddBroadcast:
+		forwardAddBroadcast(op)
+	case OpEltmul:
+		forwardEltmul(op)
+	case OpSub:
+		forwardSub(op)
+	case OpScale:
+		forwardScale(op)
+	case OpNeg:
+		forwardNeg(op)
+	case OpDiv:
+		forwardDiv(op)
+	case OpSum:
+		forwardSum(op)
+	case OpMean:
+		forwardMean(op)
+	case OpMax:
+		forwardMax(op)
+	case OpTranspose:
+		forwardTranspose(op)
+	case OpReshape, OpClone:
+		forwardCopy(op)
+	case OpConcatRows:
+		forwardConcatRows(op)
+	case OpConcatColumns:
+		forwardConcatColumns(op)
+	case OpSliceRows:
+		forwardSliceRows(op)
+	case OpSliceColumns:
+		forwardSliceColumns(op)
+	case OpGelu:
+		forwardGelu(op)
+	case OpLeakyRelu:
+		forwardLeakyRelu(op)
+	case OpPRelu:
+		forwardPRelu(op)
+	case OpElu:
+		forwardElu(op)
+	case OpSoftplus:
+		forwardSoftplus(op)
+	case OpSwish:
+		forwardSwish(op)
+	case OpHardSigmoid:
+		forwardHardSigmoid(op)
+	case OpHardTanh:
+		forwardHardTanh(op)
+	case OpLayerNorm:
+		forwardLayerNorm(op)
+	case OpDropout:
+		forwardDropout(op)
+	case OpSoftmax:
+		forwardSoftmax(op)
+	case OpLogSoftmax:
+		forwardLogSoftmax(op)
+	case OpSoftmaxCrossEntropy:
+		forwardSoftmaxCrossEntropy(op)
+	case OpLSTMCell, OpSimplifiedLSTMCell:
+		forwardLSTMCell(op, nil)
+	case OpCheckpoint:
+		forwardCheckpoint(op)
+	default:
+		panic("no forward pass for a " + op.Kind.String() + " op")
+	}
+}
+
+/*
+backward adds the gradient of the op's outputs into its inputs.
+*/
+func (op *TapeOp[T]) backward() {
+	switch op.Kind {
+	case OpFunc:
+		op.fn()
+	case OpRowPluck:
+		backwardRowPluck(op)
+	case OpRowsPluck:
+		backwardRowsPluck(op)
+	case OpTanh:
+		backwardTanh(op)
+	case OpSigmoid:
+		backwardSigmoid(op)
+	case OpRelu:
+		backwardRelu(op)
+	case OpMul:
+		backwardMul(op)
+	case OpMulBlock:
+		backwardMulBlock(op)
+	case OpAdd:
+		backwardAdd(op)
+	case OpAddBroadcast:
+		backwardAddBroadcast(op)
+	case OpEltmul:
+		backwardEltmul(op)
+	case OpSub:
+		backwardSub(op)
+	case OpScale:
+		backwardScale(op)
+	case OpNeg:
+		backwardNeg(op)
+	case OpDiv:
+		backwardDiv(op)
+	case OpSum:
+		backwardSum(op)
+	case OpMean:
+		backwardMean(op)
+	case OpMax:
+		backwardMax(op)
+	case OpTranspose:
+		backwardTranspose(op)
+	case OpReshape, OpClone:
+		backwardCopy(op)
+	case OpConcatRows:
+		backwardConcatRows(op)
+	case OpConcatColumns:
+		backwardConcatColumns(op)
+	case OpSliceRows:
+		backwardSliceRows(op)
+	case OpSliceColumns:
+		backwardSliceColumns(op)
+	case OpGelu:
+		backwardGelu(op)
+	case OpLeakyRelu:
+		backwardLeakyRelu(op)
+	case OpPRelu:
+		backwardPRelu(op)
+	case OpElu:
+		backwardElu(op)
+	case OpSoftplus:
+		backwardSoftplus(op)
+	case OpSwish:
+		backwardSwish(op)
+	case OpHardSigmoid:
+		backwardHardSigmoid(op)
+	case OpHardTanh:
+		backwardHardTanh(op)
+	case OpLayerNorm:
+		backwardLayerNorm(op)
+	case OpDropout:
+		backwardDropout(op)
+	case OpSoftmax:
+		backwardSoftmax(op)
+	case OpLogSoftmax:
+		backwardLogSoftmax(op)
+	case OpSoftmaxCrossEntropy:
+		backwardSoftmaxCrossEntropy(op)
+	case OpLSTMCell, OpSimplifiedLSTMCell:
+		backwardLSTMCell(op)
+	case OpCheckpoint:
+		backwardCheckpoint(op)
+	default:
+		panic("no backward pass for a " + op.Kind.String() + " op")
+	}
+}
+
+/*
+run computes op and, when the graph needs backprop, puts it on the tape.
+It returns the op's output.
+*/
+func (g *GraphOf[T]) run(op TapeOp[T]) *MatOf[T] {
+	op.forward()
+	if g.NeedsBackprop {
+		g.record(op)
+	}
+	return op.Output
+}
+
+/*
+record puts op on the end of the tape, and in the trace when there is one.
+*/
+func (g *GraphOf[T]) record(op TapeOp[T]) {
+	if g.Trace != nil && op.Kind != OpFunc {
+		g.Trace.record(op.Kind.String(), op.Output, op.Inputs, op.Extra...)
+	}
+	g.bpMux.Lock()
+	g.Tape = append(g.Tape, op)
+	g.useDensely(&op)
+	g.bpMux.Unlock()
+}
+
+/*
+Replay runs the forward pass of the tape again, in order, writing every op's
+outputs in place - to see what the same graph computes after its parameters
+or inputs changed, without building it again. Random ops like Dropout reuse
+what they drew the first time.
+
+Replay leaves the gradients alone. Ops added with AddBackprop have no
+forward pass to run again, so a tape with any is left as it is, with an
+error.
+*/
+func (g *GraphOf[T]) Replay() error {
+	for i := range g.Tape {
+		if g.Tape[i].Kind == OpFunc {
+			return fmt.Errorf("op %d of the tape was added with AddBackprop and can not be replayed", i)
+		}
+	}
+	for i := range g.Tape {
+		g.Tape[i].forward()
+	}
+	return nil
+}
+
+/*
+TapeStat sums up the ops of one kind on a tape.
+*/
+type TapeStat struct {
+	Kind  OpKind
+	Count int
+	Bytes int // what they hold on to, see TapeOp.Bytes
+}
+
+func (s TapeStat) String() string {
+	return fmt.Sprintf("%-20s count=%-6d bytes=%d", s.Kind, s.Count, s.Bytes)
+}
+
+/*
+TapeStats counts the ops on the tape and the memory they hold, by kind, the
+most memory first.
+*/
+func (g *GraphOf[T]) TapeStats() []TapeStat {
+	var byKind [numOpKinds]TapeStat
+	for i := range g.Tape {
+		op := &g.Tape[i]
+		byKind[op.Kind].Kind = op.Kind
+		byKind[op.Kind].Count++
+		byKind[op.Kind].Bytes += op.Bytes()
+	}
+	stats := make([]TapeStat, 0)
+	for _, s := range byKind {
+		if s.Count > 0 {
+			stats = append(stats, s)
+		}
+	}
+	sort.SliceStable(stats, func(a, b int) bool { return stats[a].Bytes > stats[b].Bytes })
+	return stats
+}
@@ -0,0 +1,51 @@
+package mat32
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+/*
+TestReplay checks Replay computes what building the graph again does, and
+turns down a tape it can not replay.
+*/
+func TestReplay(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	W := RandMat(r, 4, 3, 1)
+	x := RandMat(r, 3, 2, 1)
+	build := func(g *Graph) *Mat {
+		return g.Tanh(g.Mul(W, x))
+	}
+
+	g := &Graph{NeedsBackprop: true}
+	out := build(g)
+	x.W[0] += 0.5
+	if err := g.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	want := build(&Graph{})
+	for i := range want.W {
+		if out.W[i] != want.W[i] {
+			t.Fatalf("replayed output %d is %v, want %v", i, out.W[i], want.W[i])
+		}
+	}
+
+	g.AddBackprop(func() {}, out)
+	if err := g.Replay(); err == nil {
+		t.Errorf("a tape with an op from AddBackprop replayed")
+	}
+}
+
+func TestBackwardTwice(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	x := RandMat(r, 3, 2, 1)
+	g := &Graph{NeedsBackprop: true}
+	g.Tanh(x)
+	g.Backward()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Backward ran twice on the same tape")
+		}
+	}()
+	g.Backward()
+}
@@ -0,0 +1,123 @@
+package mat32
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+TracedOp is one op a Graph recorded: its name, output and inputs.
+*/
+type TracedOp[T Float] struct {
+	Op     string
+	Output *MatOf[T]
+	Inputs []*MatOf[T]
+	Extra  []*MatOf[T] // outputs after the first, for ops that have more
+}
+
+/*
+TraceOf records the ops a Graph builds, so the graph can be looked at - the
+backprop closures on their own say nothing about it. Set it as a Graph's
+Trace to turn tracing on; only graphs that need backprop record ops.
+
+With an Arena the Mats of a graph are reused by the next one, so write the
+trace out before the next ResetBackprop.
+*/
+type TraceOf[T Float] struct {
+	mux   sync.Mutex
+	Ops   []TracedOp[T]
+	names map[*MatOf[T]]string
+}
+
+/*
+Trace is a float32 trace.
+*/
+type Trace = TraceOf[float32]
+
+/*
+Trace64 is a float64 trace.
+*/
+type Trace64 = TraceOf[float64]
+
+func (t *TraceOf[T]) record(op string, out *MatOf[T], inputs []*MatOf[T], extra ...*MatOf[T]) {
+	t.mux.Lock()
+	t.Ops = append(t.Ops, TracedOp[T]{Op: op, Output: out, Inputs: inputs, Extra: extra})
+	t.mux.Unlock()
+}
+
+/*
+Name labels a Mat, usually a parameter by its Model key like "Wix0". Names
+outlive Reset.
+*/
+func (t *TraceOf[T]) Name(m *MatOf[T], name string) {
+	t.mux.Lock()
+	if t.names == nil {
+		t.names = make(map[*MatOf[T]]string)
+	}
+	t.names[m] = name
+	t.mux.Unlock()
+}
+
+/*
+Reset forgets the recorded ops.
+*/
+func (t *TraceOf[T]) Reset() {
+	t.mux.Lock()
+	t.Ops = nil
+	t.mux.Unlock()
+}
+
+/*
+WriteDOT writes the traced graph in Graphviz DOT. Ops are ellipses labelled
+with the op and its output shape, named Mats are boxes, and other Mats that
+no op produced - inputs and initial states - are plain shapes.
+*/
+func (t *TraceOf[T]) WriteDOT(w io.Writer, title string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "digraph %q {\n", title)
+	fmt.Fprintln(out, "\trankdir=LR;")
+	fmt.Fprintln(out, "\tnode [fontname=\"Helvetica\", fontsize=10];")
+
+	producer := make(map[*MatOf[T]]string) // Mat -> id of the node it comes from
+	leaves := 0
+	source := func(m *MatOf[T]) string {
+		if id, ok := producer[m]; ok {
+			return id
+		}
+		var id string
+		if name, ok := t.names[m]; ok {
+			id = "param_" + name
+			fmt.Fprintf(out, "\t%q [shape=box, style=filled, fillcolor=\"#e8e8ff\", label=\"%s\\n%dx%d\"];\n", id, name, m.RowCount, m.ColumnCount)
+		} else {
+			id = fmt.Sprintf("leaf%d", leaves)
+			leaves++
+			fmt.Fprintf(out, "\t%q [shape=plain, label=\"%dx%d\"];\n", id, m.RowCount, m.ColumnCount)
+		}
+		producer[m] = id
+		return id
+	}
+
+	for i, op := range t.Ops {
+		id := fmt.Sprintf("op%d", i)
+		for _, in := range op.Inputs {
+			fmt.Fprintf(out, "\t%q -> %q;\n", source(in), id)
+		}
+		label := fmt.Sprintf("%s\\n%dx%d", op.Op, op.Output.RowCount, op.Output.ColumnCount)
+		if name, ok := t.names[op.Output]; ok {
+			label = name + " = " + label
+		}
+		fmt.Fprintf(out, "\t%q [label=\"%s\"];\n", id, label)
+		producer[op.Output] = id
+		for _, extra := range op.Extra {
+			producer[extra] = id
+		}
+	}
+
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
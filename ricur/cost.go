@@ -3,9 +3,31 @@ package main
 import (
 	"math"
 	"strings"
+
+	"github.com/getlantern/errors"
 	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+	"gopkg.in/urfave/cli.v1"
 )
 
+/*
+checkpointFlag sets checkpointEvery.
+*/
+var checkpointFlag = cli.IntFlag{
+	Name:  "checkpoint-every",
+	Usage: "(optional) Gradient checkpointing: keep only the LSTM states of every `int` steps of a line for backprop, computing the steps in between again during it. Long lines take far less memory for about a third more compute. 0 keeps everything",
+}
+
+/*
+readCheckpointFlag reads checkpointFlag into checkpointEvery.
+*/
+func readCheckpointFlag(c *cli.Context) error {
+	checkpointEvery = c.Int("checkpoint-every")
+	if checkpointEvery < 0 {
+		return errors.New("--checkpoint-every must be 0 or more")
+	}
+	return nil
+}
+
 /*
 Cost represents the result of running the cost function.
 */
@@ -17,66 +39,145 @@ type Cost struct {
 /*
 CostFunction takes a model and a sentence and calculates the loss.
 */
-func (state *TrainingState) CostFunction(sent string) Cost {
-	letters := strings.Split(sent, "")
-	n := len(letters)
-	state.ResetBackprop(true)
-	var log2ppl float64
-	var cost float64
+func (state *TrainingState[T]) CostFunction(sent string) Cost {
+	return state.CostFunctionBatch([]string{sent})
+}
+
+/*
+CostFunctionBatch calculates the loss over a batch of sentences in one graph,
+one column per sentence. Sentences can have different lengths - once a
+sentence has ended its column is masked out of the loss.
+
+Gradients are averaged across the batch, and so is the returned Cost.
+*/
+func (state *TrainingState[T]) CostFunctionBatch(sents []string) Cost {
+	return state.costBatch(sents, true)
+}
 
-	var ixSource int
-	var ixTarget int
-	var prev *CellMemory
-	initial := CellMemory{}
-	prev = &initial
-	var probs *mat32.Mat
-
-	// loop through each letter of the selected sentence
-	for i := -1; i < n; i++ {
-		// start and end tokens are zeros
-		// fmt.Println("i=", i, ", n=", n)
-		// first step: start with START token
-		if i == -1 {
-			ixSource = 0
-		} else {
-			ixSource = state.LetterToIndex[letters[i]]
+/*
+Evaluate is CostFunctionBatch without building a graph to backprop, for
+measuring a model, quantized ones too.
+*/
+func (state *TrainingState[T]) Evaluate(sents []string) Cost {
+	return state.costBatch(sents, false)
+}
+
+func (state *TrainingState[T]) costBatch(sents []string, needsBackprop bool) Cost {
+	batch := len(sents)
+	letters := make([][]string, batch)
+	longest := 0
+	for b, sent := range sents {
+		letters[b] = strings.Split(sent, "")
+		if len(letters[b]) > longest {
+			longest = len(letters[b])
 		}
-		// last step: end with END token
-		if i == n-1 {
-			ixTarget = 0
-		} else {
-			ixTarget = state.LetterToIndex[letters[i+1]]
+	}
+	state.ResetBackprop(needsBackprop)
+
+	// the letters going in and the letters to predict at each step, one per
+	// sentence. A sentence is done once its END token has been predicted,
+	// and from then on its target is -1, masking it out of the loss.
+	steps := longest + 1
+	sources := make([][]int, steps)
+	targets := make([][]int, steps)
+	sourceIxs := make([]int, steps*batch)
+	targetIxs := make([]int, steps*batch)
+	done := make([]bool, batch)
+	for i := -1; i < longest; i++ {
+		ixSources := sourceIxs[(i+1)*batch : (i+2)*batch]
+		ixTargets := targetIxs[(i+1)*batch : (i+2)*batch]
+		for b := range sents {
+			n := len(letters[b])
+			if i >= n || done[b] {
+				ixTargets[b] = -1
+				continue
+			}
+			// first step: start with START token
+			if i != -1 {
+				ixSources[b] = state.LetterToIndex[letters[b][i]]
+			}
+			// last step: end with END token
+			if i != n-1 {
+				ixTargets[b] = state.LetterToIndex[letters[b][i+1]]
+			}
+			// all done? END?
+			if (state.OutputSize - 1) < ixTargets[b] {
+				done[b] = true
+				ixTargets[b] = -1
+			}
 		}
+		sources[i+1] = ixSources
+		targets[i+1] = ixTargets
+	}
+
+	// step runs the network one letter and scores what it predicted
+	step := func(i int, prev CellMemory[T]) (CellMemory[T], *mat32.MatOf[T]) {
 		// formerly ForwardIndex. Forward propagate the sequence learner.
 		lh := state.ForwardLSTM(
 			state.HiddenSizes,
-			state.RowPluck(state.Model["Wil"], ixSource),
+			state.embed(sources[i]),
 			prev,
 		)
-
-		// set gradients into logprobabilities
 		// interpret output as logrithmicProbabilities
-		probs = mat32.Softmax(lh.Output) // compute the softmax probabilities
+		return lh, state.SoftmaxCrossEntropy(lh.Output, targets[i])
+	}
 
-		// all done? END?
-		if (len(probs.W) - 1) < ixTarget {
-			break
+	// loop through each letter of the selected sentences
+	losses := make([]*mat32.MatOf[T], 0, len(sources))
+	prev := CellMemory[T]{}
+	for i := 0; i < len(sources); {
+		if checkpointEvery == 0 {
+			var loss *mat32.MatOf[T]
+			prev, loss = step(i, prev)
+			losses = append(losses, loss)
+			i++
+			continue
 		}
-		log2ppl += -math.Log2(float64(probs.W[ixTarget])) // accumulate base 2 log prob and do smoothing
-		cost += -math.Log(float64(probs.W[ixTarget]))
-
-		// write gradients into log probabilities
-		lh.Output.DW = probs.W
-		lh.Output.DW[ixTarget] -= 1
+		// only keep the states between every few steps, and the losses
+		from, to := i, min(i+checkpointEvery, len(sources))
+		start := prev
+		outs := state.Checkpoint(func() []*mat32.MatOf[T] {
+			lh := start
+			kept := make([]*mat32.MatOf[T], 0, to-from+2*len(state.HiddenSizes))
+			for j := from; j < to; j++ {
+				var loss *mat32.MatOf[T]
+				lh, loss = step(j, lh)
+				kept = append(kept, loss)
+			}
+			return append(append(kept, lh.Hidden...), lh.Cell...)
+		})
+		losses = append(losses, outs[:to-from]...)
+		layers := outs[to-from:]
+		prev = CellMemory[T]{
+			Hidden: layers[:len(state.HiddenSizes)],
+			Cell:   layers[len(state.HiddenSizes):],
+		}
+		i = to
+	}
 
-		prev = lh
+	var log2ppl float64
+	var cost float64
+	scale := T(1) / T(batch)
+	for i, loss := range losses {
+		for b := range sents {
+			if targets[i][b] < 0 {
+				continue
+			}
+			cost += float64(loss.W[b])
+			log2ppl += float64(loss.W[b]) / math.Ln2 // accumulate base 2 log prob
+			loss.DW[b] = scale                       // average across the batch
+		}
 	}
 
-	exponent := log2ppl/float64(n-1)
+	predicted := 0
+	for b := range sents {
+		predicted += len(letters[b]) - 1
+	}
+	exponent := log2ppl / float64(predicted)
 	ppl := math.Pow(2, exponent)
 
 	return Cost{
 		Ppl:  ppl,
-		Cost: cost,
+		Cost: cost / float64(batch),
 	}
 }
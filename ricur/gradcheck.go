@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+)
+
+/*
+GradCheck numerically checks the gradients that CostFunction and Backward
+produce for sent, one result per Model matrix, with finite differences of
+size delta. At most maxChecks elements of each matrix are perturbed, since a
+full check of a real network is slow.
+*/
+func (state *TrainingState[T]) GradCheck(sent string, delta T, maxChecks int) []mat32.GradCheckResult {
+	keys := make([]string, 0, len(state.Model))
+	for k := range state.Model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	forward := func() float64 {
+		return state.CostFunction(sent).Cost
+	}
+
+	results := make([]mat32.GradCheckResult, 0, len(keys))
+	for _, k := range keys {
+		// Backward writes into every matrix, not only the one being checked
+		state.zeroGradients()
+		result := mat32.CheckGradient(k, []*mat32.MatOf[T]{state.Model[k]}, forward, state.Backward, delta, maxChecks)
+		results = append(results, result)
+	}
+	state.zeroGradients()
+
+	return results
+}
+
+func (state *TrainingState[T]) zeroGradients() {
+	for _, m := range state.Model {
+		for i := range m.DW {
+			m.DW[i] = 0
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+maxCostRelError is how far the gradients of the cost function may be from
+central finite differences, in float64. A whole network is more curved than
+one op, layer norm most of all, so the differences themselves are off by
+about 1e-5 at times.
+*/
+const maxCostRelError = 1e-4
+
+/*
+TestGradCheck gradient checks the cost function of a tiny [5, 5] float64
+network, plain, simplified, layer normalized and checkpointed.
+*/
+func TestGradCheck(t *testing.T) {
+	defer func(seqlen int, simple bool, every int) {
+		sequenceLength, simplified, checkpointEvery = seqlen, simple, every
+	}(sequenceLength, simplified, checkpointEvery)
+	const sent = "the quick fox"
+	for _, variant := range []struct {
+		name       string
+		simplified bool
+		layerNorm  bool
+		checkpoint int
+	}{
+		{name: "plain"},
+		{name: "simplified", simplified: true},
+		{name: "layernorm", layerNorm: true},
+		{name: "layernorm simplified", simplified: true, layerNorm: true},
+		{name: "checkpointed", checkpoint: 3},
+	} {
+		sequenceLength = 4
+		simplified = variant.simplified
+		checkpointEvery = variant.checkpoint
+		state := &TrainingState[float64]{
+			HiddenSizes:   []int{5, 5},
+			Precision:     64,
+			LayerNormLSTM: variant.layerNorm,
+		}
+		state.Seed(1)
+		state.InitVocab([]string{sent}, 1)
+		state.InitModel()
+		// as the gradcheck command does, so the gradients are not lost in
+		// the rounding of the cost
+		for k, m := range state.Model {
+			if !strings.HasPrefix(k, "W") {
+				continue
+			}
+			for i := range m.W {
+				m.W[i] *= 10
+			}
+		}
+		for _, result := range state.GradCheck(sent, 1e-4, 0) {
+			if !(result.MaxRelError <= maxCostRelError) {
+				t.Errorf("%s: %v", variant.name, result)
+			}
+		}
+	}
+}
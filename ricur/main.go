@@ -1,18 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"math/rand"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/getlantern/errors"
 	"github.com/pkg/profile"
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -39,6 +39,20 @@ original 5.0
 */
 var clipval float32
 
+/*
+clipMode is how clipval clips the gradients: "value" caps each derivative on
+its own, "norm" scales them all down together when their global L2 norm is
+over it, which keeps the direction of the step.
+*/
+var clipMode = "value"
+
+/*
+accumulate is how many batches have their gradients summed up, and then
+averaged, before each solver step. It is a bigger batch without the memory
+of one graph that big.
+*/
+var accumulate = 1
+
 /*
 sequenceLength is how many characters to use when
 
@@ -57,6 +71,42 @@ or bias on all three gates.
 */
 var simplified = false
 
+/*
+layerNorm makes new networks with layer normalized LSTM cells.
+*/
+var layerNorm = false
+
+/*
+cellActivation and candidateActivation are the activations new networks use
+on the cell state and on the candidate cell write.
+*/
+var cellActivation = "tanh"
+var candidateActivation = "tanh"
+
+/*
+dropout is the fraction of activations dropped between stacked LSTM layers
+and before the decoder, while training.
+*/
+var dropout float32
+
+/*
+initializers is how the parameters of a new network start out.
+*/
+var initializers = DefaultInitializers()
+
+/*
+batchSize is how many sentences are trained on together in one graph, with
+their gradients averaged before each solver step.
+*/
+var batchSize = 1
+
+/*
+checkpointEvery, when over 0, keeps only the LSTM states of every that many
+steps of a line until Backward, which computes the steps in between again.
+Long lines take far less memory, for about one more forward pass.
+*/
+var checkpointEvery = 0
+
 /* */
 
 // prediction params
@@ -64,13 +114,6 @@ var simplified = false
 // max length of generated sentences
 const maxCharsGenerate = 500
 
-// various global var inits
-
-// should be class because it needs memory for step caches
-var solverecurrent *Solver
-
-// old gradCheck was here.
-
 func main() {
 	app := cli.NewApp()
 	app.Name = "ricur: A recurrent neural trainer for general text prediction."
@@ -121,6 +164,16 @@ func main() {
 					Value: 5.0,
 					Usage: "(optional) Gradient Clip: `float32` max value allowed for derivatives of weights before they are capped",
 				},
+				cli.StringFlag{
+					Name:  "clip",
+					Value: clipMode,
+					Usage: "(optional) How --gradmax clips: `mode` value caps each derivative, norm scales all of them down when their global L2 norm is over it",
+				},
+				cli.IntFlag{
+					Name:  "accumulate",
+					Value: 1,
+					Usage: "(optional) Sum the gradients of `int` batches, and average them, before each training step",
+				},
 				cli.Float64Flag{
 					Name:  "seqlen",
 					Value: 40,
@@ -130,13 +183,103 @@ func main() {
 					Name:  "simplified",
 					Usage: "(optional) Use a simplified bias calculation (from LSTM2, Lu & Salem, 2017). Should be faster but ideally uses learn rate of 0.0001.",
 				},
+				cli.BoolFlag{
+					Name:  "layernorm",
+					Usage: "(optional) For a new network, layer normalize the LSTM gate pre-activations and cell state. Helps deep stacks train stably.",
+				},
+				cli.StringFlag{
+					Name:  "cell-activation",
+					Value: cellActivation,
+					Usage: "(optional) For a new network, the `activation` of the cell state: " + strings.Join(mat32.ActivationNames(), ", ") + ". hardtanh is cheaper than tanh",
+				},
+				cli.StringFlag{
+					Name:  "candidate-activation",
+					Value: candidateActivation,
+					Usage: "(optional) For a new network, the `activation` of the candidate cell write, from the same list",
+				},
+				cli.Float64Flag{
+					Name:  "dropout",
+					Usage: "(optional) Dropout: `float32` fraction of activations zeroed between stacked layers and before the decoder, while training",
+				},
+				cli.IntFlag{
+					Name:  "batch",
+					Value: 1,
+					Usage: "(optional) Minibatch: `int` number of sentences per training step, gradients are averaged across them",
+				},
+				cli.StringFlag{
+					Name:  "init-input",
+					Value: initializers.Input.String(),
+					Usage: "(optional) For a new network, the `initializer` of the letter embeddings and input weights: uniform:SCALE, normal:STD, xavier, he, orthogonal[:GAIN] or constant:VALUE",
+				},
+				cli.StringFlag{
+					Name:  "init-recurrent",
+					Value: initializers.Recurrent.String(),
+					Usage: "(optional) For a new network, the `initializer` of the recurrent weights. orthogonal is a common choice",
+				},
+				cli.StringFlag{
+					Name:  "init-forget-bias",
+					Value: initializers.ForgetBias.String(),
+					Usage: "(optional) For a new network, the `initializer` of the forget gate bias. constant:1 makes cells remember by default",
+				},
+				cli.StringFlag{
+					Name:  "init-decoder",
+					Value: initializers.Decoder.String(),
+					Usage: "(optional) For a new network, the `initializer` of the decoder weights",
+				},
+				checkpointFlag,
+				precisionFlag,
+				storageFlag,
+				randomSeedFlag,
 			},
 			Before: func(c *cli.Context) error {
+				setRandomSeed(c)
+				if err := readCheckpointFlag(c); err != nil {
+					return err
+				}
+				if err := readStorageFlag(c); err != nil {
+					return err
+				}
 				learningRate = float32(c.Float64("learn"))
 				regc = float32(c.Float64("regc"))
 				clipval = float32(c.Float64("gradmax"))
+				clipMode = c.String("clip")
+				if clipMode != "value" && clipMode != "norm" {
+					return errors.New("--clip must be value or norm")
+				}
+				accumulate = c.Int("accumulate")
+				if accumulate < 1 {
+					return errors.New("--accumulate must be at least 1")
+				}
 				sequenceLength = c.Int("seqlen")
 				simplified = c.Bool("simplified")
+				layerNorm = c.Bool("layernorm")
+				cellActivation = c.String("cell-activation")
+				candidateActivation = c.String("candidate-activation")
+				for _, name := range []string{cellActivation, candidateActivation} {
+					if _, err := mat32.ActivationOf[float32](name); err != nil {
+						return err
+					}
+				}
+				dropout = float32(c.Float64("dropout"))
+				if dropout < 0 || dropout >= 1 {
+					return errors.New("--dropout must be at least 0 and less than 1")
+				}
+				batchSize = c.Int("batch")
+				if batchSize < 1 {
+					return errors.New("--batch must be at least 1")
+				}
+				for flag, init := range map[string]*mat32.Initializer{
+					"init-input":       &initializers.Input,
+					"init-recurrent":   &initializers.Recurrent,
+					"init-forget-bias": &initializers.ForgetBias,
+					"init-decoder":     &initializers.Decoder,
+				} {
+					parsed, err := mat32.ParseInitializer(c.String(flag))
+					if err != nil {
+						return errors.New("--" + flag + ": " + err.Error())
+					}
+					*init = parsed
+				}
 
 				return nil
 			},
@@ -146,19 +289,20 @@ func main() {
 					// cut of beginning default if user passed custom
 					hidden = hidden[3:]
 				}
-				return training(
-					c.String("seed"),
-					c.String("in"),
-					c.String("load"),
-					c.String("save"),
-					hidden,
-				)
+				bits, err := resolvePrecision(c, c.String("load"))
+				if err != nil {
+					return err
+				}
+				if bits == 64 {
+					return training[float64](c.String("seed"), c.String("in"), c.String("load"), c.String("save"), hidden)
+				}
+				return training[float32](c.String("seed"), c.String("in"), c.String("load"), c.String("save"), hidden)
 			},
 		},
 		{
 			Name:  "sample",
 			Usage: "Run and receive output from an existing neural network",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "load",
 					Usage: "`file` path to load an existing model",
@@ -167,36 +311,255 @@ func main() {
 					Name:  "seed",
 					Usage: "Text to use in prediction",
 				},
-			},
+				precisionFlag,
+				randomSeedFlag,
+			}, samplerFlags...),
+			Before: setRandomSeed,
 			Action: func(c *cli.Context) error {
 				loadFilepath := c.String("load")
 				if loadFilepath == "" {
 					return errors.New("Missing required filepath to model: --load")
 				}
-				s, err := ioutil.ReadFile(loadFilepath)
+				sampler, err := samplerFromFlags(c)
 				if err != nil {
 					return err
 				}
-				state := &TrainingState{}
-				err = json.Unmarshal(s, state)
+				bits, err := resolvePrecision(c, loadFilepath)
+				if err != nil {
+					return err
+				}
+				if bits == 64 {
+					return sample[float64](loadFilepath, c.String("seed"), sampler)
+				}
+				return sample[float32](loadFilepath, c.String("seed"), sampler)
+			},
+		},
+		{
+			Name:  "gradcheck",
+			Usage: "Numerically verify the backprop of every graph op, and of the cost function on a network",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "load",
+					Usage: "Optional `file` path to load an existing model. Without it, a tiny network is created from --seed.",
+				},
+				cli.StringFlag{
+					Name:  "seed",
+					Value: "The quick brown fox.",
+					Usage: "Sentence `text` to run through the cost function",
+				},
+				cli.IntSliceFlag{
+					Name:  "hidden",
+					Value: &cli.IntSlice{6, 5},
+					Usage: "Hidden layer sizes for the network created when --load is not used",
+				},
+				cli.IntFlag{
+					Name:  "seqlen",
+					Value: 4,
+					Usage: "Sequence length for the network created when --load is not used",
+				},
+				cli.BoolFlag{
+					Name:  "layernorm",
+					Usage: "Layer normalize the network created when --load is not used",
+				},
+				cli.StringFlag{
+					Name:  "cell-activation",
+					Value: "tanh",
+					Usage: "Cell state `activation` of the network created when --load is not used",
+				},
+				cli.StringFlag{
+					Name:  "candidate-activation",
+					Value: "tanh",
+					Usage: "Candidate cell write `activation` of the network created when --load is not used",
+				},
+				cli.Float64Flag{
+					Name:  "delta",
+					Value: float64(mat32.GradCheckDelta),
+					Usage: "Finite difference step `float32`. Layer normalized networks are strongly curved and want a smaller one, like 0.001",
+				},
+				cli.IntFlag{
+					Name:  "checks",
+					Value: 20,
+					Usage: "Max `int` elements perturbed per model matrix",
+				},
+				checkpointFlag,
+				precisionFlag,
+				randomSeedFlag,
+			},
+			Before: func(c *cli.Context) error {
+				setRandomSeed(c)
+				return readCheckpointFlag(c)
+			},
+			Action: func(c *cli.Context) error {
+				bits, err := resolvePrecision(c, c.String("load"))
 				if err != nil {
-					fmt.Println("state=", state)
 					return err
 				}
+				if bits == 64 {
+					return gradcheck[float64](c)
+				}
+				return gradcheck[float32](c)
+			},
+		},
+		{
+			Name:  "graph",
+			Usage: "Write the computation graph of one training step, or one LSTM timestep, as Graphviz DOT",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "load",
+					Usage: "Optional `file` path to load an existing model. Without it, a small network is created from --seed.",
+				},
+				cli.StringFlag{
+					Name:  "seed",
+					Value: "hi",
+					Usage: "Sentence `text` to run through the cost function",
+				},
+				cli.IntSliceFlag{
+					Name:  "hidden",
+					Value: &cli.IntSlice{8, 8},
+					Usage: "Hidden layer sizes for the network created when --load is not used",
+				},
+				cli.BoolFlag{
+					Name:  "layernorm",
+					Usage: "Layer normalize the network created when --load is not used",
+				},
+				cli.BoolFlag{
+					Name:  "timestep",
+					Usage: "Only graph a single LSTM timestep instead of the whole sentence",
+				},
+				cli.StringFlag{
+					Name:  "out",
+					Value: "graph.dot",
+					Usage: "`file` path to write the DOT to. Render it with: dot -Tsvg graph.dot > graph.svg",
+				},
+				cli.BoolFlag{
+					Name:  "stats",
+					Usage: "Also print how many ops of each kind are on the tape, and the memory they hold",
+				},
+				checkpointFlag,
+			},
+			Before: readCheckpointFlag,
+			Action: func(c *cli.Context) error {
+				var state *TrainingState[float32]
+				sent := c.String("seed")
+				if loadFilepath := c.String("load"); loadFilepath != "" {
+					var err error
+					state, err = loadState[float32](loadFilepath)
+					if err != nil {
+						return err
+					}
+					if state.quantized() {
+						return errors.New("A quantized model has no training graph")
+					}
+				} else {
+					hidden := c.IntSlice("hidden")
+					if c.IsSet("hidden") {
+						// cut of beginning default if user passed custom
+						hidden = hidden[2:]
+					}
+					sequenceLength = 5
+					state = &TrainingState[float32]{HiddenSizes: hidden, LayerNormLSTM: c.Bool("layernorm")}
+					err := state.initRandom()
+					if err != nil {
+						return err
+					}
+					state.InitVocab([]string{sent}, 1)
+					state.InitModel()
+				}
 
-				sentences := strings.Split(c.String("seed"), "\n")
-				solver := NewSolver()
-				for i := 0; i < len(sentences); i++ {
-					// load up the gradients before prediction
-					fmt.Println("--", sentences[i], "--")
-					state.CostFunction(sentences[i])
-					state.Backward()
-					state.StepSolver(solver, learningRate, regc, clipval)
-					pred := state.PredictSentence(maxCharsGenerate, sentences[i])
-					fmt.Println(pred)
+				state.Trace = &mat32.Trace{}
+				for k, m := range state.Model {
+					state.Trace.Name(m, k)
+				}
+				title := "training step: " + sent
+				if c.Bool("timestep") {
+					title = "LSTM timestep"
+					state.ResetBackprop(true)
+					x := state.RowPluck(state.Model["Wil"], 0)
+					state.ForwardLSTM(state.HiddenSizes, x, CellMemory[float32]{})
+				} else {
+					state.CostFunction(sent)
 				}
 
-				return nil
+				var dot bytes.Buffer
+				err := state.Trace.WriteDOT(&dot, title)
+				if err != nil {
+					return err
+				}
+				fmt.Println(len(state.Trace.Ops), "ops written to", c.String("out"))
+				if c.Bool("stats") {
+					for _, stat := range state.TapeStats() {
+						fmt.Println(" ", stat)
+					}
+				}
+				return writeFileContents(c.String("out"), dot.Bytes())
+			},
+		},
+		{
+			Name:  "convert",
+			Usage: "Convert a saved model to another precision or storage",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "load",
+					Usage: "`file` path of the model to convert",
+				},
+				cli.StringFlag{
+					Name:  "save",
+					Usage: "`file` path to save the converted model",
+				},
+				precisionFlag,
+				storageFlag,
+			},
+			Before: readStorageFlag,
+			Action: func(c *cli.Context) error {
+				loadFilepath := c.String("load")
+				saveFilepath := c.String("save")
+				if loadFilepath == "" || saveFilepath == "" {
+					return errors.New("Missing required filepaths: --load and --save")
+				}
+				bits, err := resolvePrecision(c, "")
+				if err != nil {
+					return err
+				}
+				if bits == 64 {
+					return convert[float64](loadFilepath, saveFilepath)
+				}
+				return convert[float32](loadFilepath, saveFilepath)
+			},
+		},
+		{
+			Name:  "quantize",
+			Usage: "Quantize the weights of a saved model to int8 for faster, smaller sampling",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "load",
+					Usage: "`file` path of the model to quantize",
+				},
+				cli.StringFlag{
+					Name:  "save",
+					Usage: "`file` path to save the quantized model",
+				},
+				cli.StringFlag{
+					Name:  "heldout",
+					Usage: "(optional) Held-out text `file` to compare the perplexity of the quantized model to the original on",
+				},
+				precisionFlag,
+				randomSeedFlag,
+			},
+			Before: setRandomSeed,
+			Action: func(c *cli.Context) error {
+				loadFilepath := c.String("load")
+				saveFilepath := c.String("save")
+				if loadFilepath == "" || saveFilepath == "" {
+					return errors.New("Missing required filepaths: --load and --save")
+				}
+				bits, err := resolvePrecision(c, loadFilepath)
+				if err != nil {
+					return err
+				}
+				if bits == 64 {
+					return quantize[float64](loadFilepath, saveFilepath, c.String("heldout"))
+				}
+				return quantize[float32](loadFilepath, saveFilepath, c.String("heldout"))
 			},
 		},
 	}
@@ -204,7 +567,7 @@ func main() {
 	app.Run(os.Args)
 }
 
-func training(inputSeed string, inputFile string, loadFilepath string, saveFilepath string, defaultHiddenLayers []int) (err error) {
+func training[T mat32.Float](inputSeed string, inputFile string, loadFilepath string, saveFilepath string, defaultHiddenLayers []int) (err error) {
 	// cpu profiling via PERF environment flag
 	if profileWhich := os.Getenv("PERF"); profileWhich != "" {
 		if profileWhich == "mem" {
@@ -218,21 +581,24 @@ func training(inputSeed string, inputFile string, loadFilepath string, saveFilep
 	fmt.Println("  regularization=", regc)
 	fmt.Println("  gradient clip=", clipval)
 	fmt.Println("  sequence length=", sequenceLength)
+	fmt.Println("  dropout=", dropout)
+	fmt.Println("  batch size=", batchSize)
+	if checkpointEvery > 0 {
+		fmt.Println("  checkpoint every=", checkpointEvery)
+	}
+	fmt.Println("  precision=", precisionBits[T]())
 
 	// this is where the training state is held in memory, not in global scope
 	// most importantly, to prevent leaks.
 	// (could also fetch from disk)
-	var state *TrainingState
+	var state *TrainingState[T]
 	if loadFilepath != "" {
-		s, err := ioutil.ReadFile(loadFilepath)
+		state, err = loadState[T](loadFilepath)
 		if err != nil {
 			return err
 		}
-		state = &TrainingState{}
-		err = json.Unmarshal(s, state)
-		if err != nil {
-			fmt.Println("state=", state)
-			return err
+		if state.quantized() {
+			return errors.New("Cannot train a quantized model")
 		}
 		fmt.Println("Loaded network\n ", state.HiddenSizes)
 	} else {
@@ -241,18 +607,30 @@ func training(inputSeed string, inputFile string, loadFilepath string, saveFilep
 		if len(defaultHiddenLayers) == 0 {
 			return errors.New("Cannot create a new network that is empty")
 		}
-		state = &TrainingState{
-			HiddenSizes: defaultHiddenLayers,
-			EpochSize:   -1,
-			InputSize:   -1,
-			OutputSize:  -1,
+		state = &TrainingState[T]{
+			HiddenSizes:         defaultHiddenLayers,
+			LayerNormLSTM:       layerNorm,
+			CellActivation:      cellActivation,
+			CandidateActivation: candidateActivation,
+			Precision:           precisionBits[T](),
+			Storage:             storage,
+			EpochSize:           -1,
+			InputSize:           -1,
+			OutputSize:          -1,
+		}
+		err = state.initRandom()
+		if err != nil {
+			return err
 		}
 		fmt.Println("Created new network\n ", state.HiddenSizes)
+		fmt.Println("  input=", initializers.Input, "recurrent=", initializers.Recurrent, "forget bias=", initializers.ForgetBias, "decoder=", initializers.Decoder)
 	}
 
 	state.PerplexityList = make([]float64, 0)
+	state.Arena = &mat32.ArenaOf[T]{} // recycle each tick's Mats on the next one
 
-	solverecurrent = NewSolver() // reinit solver
+	// should be class because it needs memory for step caches
+	solver := NewSolver[T]()
 	state.TickIterator = 0
 
 	// process the input, filter out blanks
@@ -278,37 +656,60 @@ func training(inputSeed string, inputFile string, loadFilepath string, saveFilep
 	state.EpochSize = len(state.DataSentences)
 	if loadFilepath == "" {
 		state.InitModel()
+	} else if hasStorage {
+		state.Storage = storage
+	}
+	state.initMasterWeights()
+	if state.Storage != "" {
+		fmt.Println("Computing with", state.Storage, "weights, stepping full precision master weights")
 	}
 
 	for {
-		tick(state, saveFilepath)
+		tick(state, solver, saveFilepath)
 	}
 
 	return err
 }
 
-func tick(state *TrainingState, saveFilepath string) {
-	// sample sentence from data
-	sentix := randi(0, len(state.DataSentences))
-	sent := state.DataSentences[sentix]
-
+func tick[T mat32.Float](state *TrainingState[T], solver *Solver[T], saveFilepath string) {
 	t0 := time.Now().UnixNano() / 1000000 // log start timestamp ms
 
-	// evaluate cost func on a sentence
-	costStruct := state.CostFunction(sent)
-	// use built up graph to compute backprop (set .DW fields in mats)
-	state.Backward()
+	for a := 0; a < accumulate; a++ {
+		// sample sentences from data
+		sents := make([]string, batchSize)
+		for b := range sents {
+			sentix := randi(state.Rand, 0, len(state.DataSentences))
+			sents[b] = state.DataSentences[sentix]
+		}
 
-	// perform param update
-	state.StepSolver(solverecurrent, learningRate, regc, clipval)
+		// evaluate cost func on the sentences
+		costStruct := state.CostFunctionBatch(sents)
+		// use built up graph to compute backprop (set .DW fields in mats).
+		// it adds to the gradients of the batches before.
+		state.Backward()
+
+		// keep track of perplexity between printing progress
+		state.PerplexityList = append(state.PerplexityList, costStruct.Ppl)
+	}
+
+	// average the accumulated gradients, and clip their norm if asked
+	gradScale := 1 / float64(accumulate)
+	gradNorm := state.GradNorm() * gradScale
+	if clipMode == "norm" && gradNorm > float64(clipval) {
+		gradScale *= float64(clipval) / gradNorm
+	}
+	state.GradNormList = append(state.GradNormList, gradNorm)
 
-	// keep track of perplexity between printing progress
-	state.PerplexityList = append(state.PerplexityList, costStruct.Ppl)
+	// perform param update
+	state.withMasterWeights(func() {
+		state.StepSolver(solver, T(learningRate), T(regc), T(clipval), T(gradScale))
+	})
 
 	// evaluate now and then
 	state.TickIterator++
 
-	epoch := float64(state.TickIterator) / float64(state.EpochSize)
+	// each tick trains on accumulate batches of sentences
+	epoch := float64(state.TickIterator*batchSize*accumulate) / float64(state.EpochSize)
 
 	if math.Remainder(float64(state.TickIterator), 250) == 0 {
 		t1 := time.Now().UnixNano() / 1000000 // ms
@@ -318,7 +719,7 @@ func tick(state *TrainingState, saveFilepath string) {
 		fmt.Println("---------------------")
 		// draw samples
 		for q := 0; q < 2; q++ {
-			pred = state.PredictSentence(maxCharsGenerate, "")
+			pred = state.PredictSentence(maxCharsGenerate, "", mat32.Sampler{Temperature: 1})
 			fmt.Println(pred)
 		}
 		fmt.Println("---------------------")
@@ -328,6 +729,8 @@ func tick(state *TrainingState, saveFilepath string) {
 		fmt.Println("epoch=", epoch)
 		fmt.Println("ticktime", tickTime, "ms")
 		fmt.Println("medianPerplexity", medianPerplexity)
+		fmt.Println("medianGradNorm", median(state.GradNormList), "max", slices.Max(state.GradNormList))
+		state.GradNormList = make([]float64, 0)
 
 		isNewEpoch := epoch != 0 && (epoch-state.lastSaveEpoch > .1)
 		if isNewEpoch {
@@ -337,9 +740,14 @@ func tick(state *TrainingState, saveFilepath string) {
 	}
 }
 
-func saveState(state *TrainingState, saveFilepath string) {
+func saveState[T mat32.Float](state *TrainingState[T], saveFilepath string) {
 	fmt.Println("Saving progress...", saveFilepath)
-	jsonState, err := json.Marshal(state)
+	err := state.saveRandom()
+	if err != nil {
+		fmt.Println("random state err", err)
+		return
+	}
+	jsonState, err := state.marshal()
 	if err != nil {
 		fmt.Println("stringify err", err)
 		return
@@ -352,6 +760,109 @@ func saveState(state *TrainingState, saveFilepath string) {
 	}
 }
 
+func sample[T mat32.Float](loadFilepath string, seed string, sampler mat32.Sampler) error {
+	state, err := loadState[T](loadFilepath)
+	if err != nil {
+		return err
+	}
+
+	sentences := strings.Split(seed, "\n")
+	solver := NewSolver[T]()
+	for i := 0; i < len(sentences); i++ {
+		// load up the gradients before prediction
+		fmt.Println("--", sentences[i], "--")
+		if !state.quantized() { // int8 weights can not be stepped
+			state.CostFunction(sentences[i])
+			state.Backward()
+			state.StepSolver(solver, T(learningRate), T(regc), T(clipval), 1)
+		}
+		pred := state.PredictSentence(maxCharsGenerate, sentences[i], sampler)
+		fmt.Println(pred)
+	}
+
+	return nil
+}
+
+func gradcheck[T mat32.Float](c *cli.Context) error {
+	delta := T(c.Float64("delta"))
+	var state *TrainingState[T]
+	sent := c.String("seed")
+	if loadFilepath := c.String("load"); loadFilepath != "" {
+		var err error
+		state, err = loadState[T](loadFilepath)
+		if err != nil {
+			return err
+		}
+		if state.quantized() {
+			return errors.New("A quantized model has no gradients to check")
+		}
+	} else {
+		hidden := c.IntSlice("hidden")
+		if c.IsSet("hidden") {
+			// cut of beginning default if user passed custom
+			hidden = hidden[2:]
+		}
+		sequenceLength = c.Int("seqlen")
+		state = &TrainingState[T]{
+			HiddenSizes:         hidden,
+			LayerNormLSTM:       c.Bool("layernorm"),
+			CellActivation:      c.String("cell-activation"),
+			CandidateActivation: c.String("candidate-activation"),
+		}
+		for _, name := range []string{state.CellActivation, state.CandidateActivation} {
+			if _, err := mat32.ActivationOf[T](name); err != nil {
+				return err
+			}
+		}
+		err := state.initRandom()
+		if err != nil {
+			return err
+		}
+		state.InitVocab([]string{sent}, 1)
+		state.InitModel()
+		// at the usual init scale a tiny network has gradients
+		// that are lost in float32 rounding, so blow its weights up
+		for k, m := range state.Model {
+			if !strings.HasPrefix(k, "W") {
+				continue
+			}
+			for i := range m.W {
+				m.W[i] *= 10
+			}
+		}
+	}
+
+	fmt.Println("Graph ops:")
+	for _, r := range mat32.CheckOps(state.Rand, delta) {
+		fmt.Println(" ", r)
+	}
+
+	fmt.Println("Cost function:")
+	for _, r := range state.GradCheck(sent, delta, c.Int("checks")) {
+		fmt.Println(" ", r)
+	}
+	return nil
+}
+
+/*
+convert rewrites a saved model with weights of T.
+*/
+func convert[T mat32.Float](loadFilepath string, saveFilepath string) error {
+	state, err := loadState[T](loadFilepath)
+	if err != nil {
+		return err
+	}
+	if hasStorage {
+		state.setStorage(storage)
+	}
+	fmt.Println("Converting to", state.Precision, "bit weights, stored as", storageName(state.Storage))
+	jsonState, err := state.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileContents(saveFilepath, jsonState)
+}
+
 func median(values []float64) (middleValue float64) {
 	sort.Float64s(values)
 	lenValues := len(values)
@@ -359,12 +870,3 @@ func median(values []float64) (middleValue float64) {
 	middleValue = values[halfway]
 	return middleValue
 }
-
-/*
-randi makes random integers between two integers
-*/
-func randi(low int, hi int) int {
-	a := float64(low)
-	b := float64(hi)
-	return int(math.Floor(rand.Float64()*(b-a) + a))
-}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+)
+
+/*
+testSentence is 43 characters, every letter in it, for a small vocab.
+*/
+const testSentence = "the quick brown fox jumps over the lazy dog"
+
+/*
+newTestState makes a [20, 20] network over the letters of testSentence,
+training the way train sets it up.
+*/
+func newTestState() (*TrainingState[float32], *Solver[float32]) {
+	sequenceLength = 10
+	state := &TrainingState[float32]{
+		HiddenSizes: []int{20, 20},
+		Precision:   32,
+	}
+	state.Seed(1)
+	state.InitVocab([]string{testSentence}, 1)
+	state.InitModel()
+	state.initMasterWeights()
+	state.Arena = &mat32.Arena{}
+	return state, NewSolver[float32]()
+}
+
+/*
+trainStep is what tick does with one sentence: the cost, its backprop and
+the solver step.
+*/
+func trainStep(state *TrainingState[float32], solver *Solver[float32], sents []string) {
+	state.CostFunctionBatch(sents)
+	state.Backward()
+	state.StepSolver(solver, 0.01, 0.000001, 5, 1)
+}
+
+/*
+maxTickAllocations is what a tick may allocate once the arena and the
+graph's buffers have warmed up: a few buffers of the cost function, and a
+goroutine for each parameter in StepSolver. None of it is per letter.
+Backward starts a goroutine per thread on top.
+*/
+const maxTickAllocations = 30
+
+func TestTickAllocations(t *testing.T) {
+	state, solver := newTestState()
+	limit := maxTickAllocations + runtime.NumCPU()
+	for _, sent := range []string{testSentence, strings.Repeat(testSentence+" ", 4)} {
+		sents := []string{sent}
+		// the arena's free lists settle after the first couple
+		for i := 0; i < 3; i++ {
+			trainStep(state, solver, sents)
+		}
+		allocs := testing.AllocsPerRun(20, func() {
+			trainStep(state, solver, sents)
+		})
+		t.Logf("%.0f allocations a tick of %d letters", allocs, len(sent))
+		if allocs > float64(limit) {
+			t.Errorf("a tick of %d letters made %.0f allocations, want at most %d", len(sent), allocs, limit)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math/rand/v2"
 	"strconv"
 	"github.com/ruffrey/recurrent-nn-char-go/mat32"
 )
@@ -8,22 +9,49 @@ import (
 /*
 Model is the graph model.
 */
-type Model map[string]*mat32.Mat
+type Model[T mat32.Float] map[string]*mat32.MatOf[T]
 
 /*
 CellMemory is apparently passed around during foward LSTM sessions.
 */
-type CellMemory struct {
-	Hidden []*mat32.Mat
-	Cell   []*mat32.Mat
-	Output *mat32.Mat
+type CellMemory[T mat32.Float] struct {
+	Hidden []*mat32.MatOf[T]
+	Cell   []*mat32.MatOf[T]
+	Output *mat32.MatOf[T]
+}
+
+/*
+Initializers picks how each class of LSTM parameter starts out. The other
+biases start at zero.
+*/
+type Initializers struct {
+	Input      mat32.Initializer // letter embeddings and the W*x gate weights
+	Recurrent  mat32.Initializer // the W*h gate weights
+	ForgetBias mat32.Initializer // bf, which some setups start at 1 so cells remember by default
+	Decoder    mat32.Initializer // Whd
+}
+
+/*
+DefaultInitializers is the original setup: uniform(-0.08, 0.08) weights and
+zero biases.
+*/
+func DefaultInitializers() Initializers {
+	return Initializers{
+		Input:      mat32.Uniform{Scale: 0.08},
+		Recurrent:  mat32.Uniform{Scale: 0.08},
+		ForgetBias: mat32.Constant{Value: 0},
+		Decoder:    mat32.Uniform{Scale: 0.08},
+	}
 }
 
 /*
 NewLSTMModel initializes a Long Short Term Memory Recurrent Neural Network model.
+
+Each depth ds gets one stacked weight matrix "W"+ds and bias "b"+ds for all
+four of its gates, the layout mat32's LSTMCell takes. See stackLSTM.
 */
-func NewLSTMModel(inputSize int, hiddenSizes []int, outputSize int) Model {
-	model := Model{}
+func NewLSTMModel[T mat32.Float](r *rand.Rand, inits Initializers, inputSize int, hiddenSizes []int, outputSize int) Model[T] {
+	model := Model[T]{}
 	var prevSize int
 	var hiddenSize int
 
@@ -36,24 +64,105 @@ func NewLSTMModel(inputSize int, hiddenSizes []int, outputSize int) Model {
 		hiddenSize = hiddenSizes[d]
 
 		ds := strconv.Itoa(d)
-		// gates parameters
-		model["Wix"+ds] = mat32.RandMat(hiddenSize, prevSize, 0.08)
-		model["Wih"+ds] = mat32.RandMat(hiddenSize, hiddenSize, 0.08)
-		model["bi"+ds] = mat32.NewMat(hiddenSize, 1)
-		model["Wfx"+ds] = mat32.RandMat(hiddenSize, prevSize, 0.08)
-		model["Wfh"+ds] = mat32.RandMat(hiddenSize, hiddenSize, 0.08)
-		model["bf"+ds] = mat32.NewMat(hiddenSize, 1)
-		model["Wox"+ds] = mat32.RandMat(hiddenSize, prevSize, 0.08)
-		model["Woh"+ds] = mat32.RandMat(hiddenSize, hiddenSize, 0.08)
-		model["bo"+ds] = mat32.NewMat(hiddenSize, 1)
+		// gates parameters, made one gate at a time so each block gets
+		// its own initialization (like an orthogonal Wih), then stacked
+		model["Wix"+ds] = mat32.InitMatOf[T](r, hiddenSize, prevSize, inits.Input)
+		model["Wih"+ds] = mat32.InitMatOf[T](r, hiddenSize, hiddenSize, inits.Recurrent)
+		model["bi"+ds] = mat32.NewMatOf[T](hiddenSize, 1)
+		model["Wfx"+ds] = mat32.InitMatOf[T](r, hiddenSize, prevSize, inits.Input)
+		model["Wfh"+ds] = mat32.InitMatOf[T](r, hiddenSize, hiddenSize, inits.Recurrent)
+		model["bf"+ds] = mat32.InitMatOf[T](r, hiddenSize, 1, inits.ForgetBias)
+		model["Wox"+ds] = mat32.InitMatOf[T](r, hiddenSize, prevSize, inits.Input)
+		model["Woh"+ds] = mat32.InitMatOf[T](r, hiddenSize, hiddenSize, inits.Recurrent)
+		model["bo"+ds] = mat32.NewMatOf[T](hiddenSize, 1)
 		// cell write params
-		model["Wcx"+ds] = mat32.RandMat(hiddenSize, prevSize, 0.08)
-		model["Wch"+ds] = mat32.RandMat(hiddenSize, hiddenSize, 0.08)
-		model["bc"+ds] = mat32.NewMat(hiddenSize, 1)
+		model["Wcx"+ds] = mat32.InitMatOf[T](r, hiddenSize, prevSize, inits.Input)
+		model["Wch"+ds] = mat32.InitMatOf[T](r, hiddenSize, hiddenSize, inits.Recurrent)
+		model["bc"+ds] = mat32.NewMatOf[T](hiddenSize, 1)
+		stackLSTM(model, ds)
 	}
 	// decoder params
-	model["Whd"] = mat32.RandMat(outputSize, hiddenSize, 0.08)
-	model["bd"] = mat32.NewMat(outputSize, 1)
+	model["Whd"] = mat32.InitMatOf[T](r, outputSize, hiddenSize, inits.Decoder)
+	model["bd"] = mat32.NewMatOf[T](outputSize, 1)
+
+	return model
+}
+
+/*
+lstmGates are the keys of the per-gate LSTM parameters, in the order they
+are stacked: the input, forget and output gates, then the cell write.
+*/
+var lstmGates = [4][3]string{
+	{"Wix", "Wih", "bi"},
+	{"Wfx", "Wfh", "bf"},
+	{"Wox", "Woh", "bo"},
+	{"Wcx", "Wch", "bc"},
+}
+
+/*
+stackLSTM replaces depth ds's per-gate parameters (Wix, Wih, bi and so on)
+with the stacked "W"+ds, which is [Wix Wih; Wfx Wfh; Wox Woh; Wcx Wch], and
+"b"+ds, which is [bi; bf; bo; bc]. Models saved before the LSTM cell was
+fused have the per-gate keys, so this is also how they get loaded.
+
+It returns false, leaving the model alone, when any per-gate key is missing.
+*/
+func stackLSTM[T mat32.Float](model Model[T], ds string) bool {
+	for _, gate := range lstmGates {
+		for _, k := range gate {
+			if _, ok := model[k+ds]; !ok {
+				return false
+			}
+		}
+	}
+
+	hiddenSize := model["Wih"+ds].RowCount
+	prevSize := model["Wix"+ds].ColumnCount
+	W := mat32.NewMatOf[T](4*hiddenSize, prevSize+hiddenSize)
+	b := mat32.NewMatOf[T](4*hiddenSize, 1)
+	for g, gate := range lstmGates {
+		wx, wh, bias := model[gate[0]+ds], model[gate[1]+ds], model[gate[2]+ds]
+		for r := 0; r < hiddenSize; r++ {
+			row := (g*hiddenSize + r) * W.ColumnCount
+			copy(W.W[row:], wx.W[r*prevSize:(r+1)*prevSize])
+			copy(W.W[row+prevSize:], wh.W[r*hiddenSize:(r+1)*hiddenSize])
+		}
+		copy(b.W[g*hiddenSize:], bias.W)
+		for _, k := range gate {
+			delete(model, k+ds)
+		}
+	}
+	model["W"+ds] = W
+	model["b"+ds] = b
+	return true
+}
+
+/*
+NewLayerNormModel makes the layer norm gains for a layer normalized LSTM: one
+per gate pre-activation and one (with a bias) for the cell state. The gates
+reuse their LSTM biases as the layer norm shift.
+*/
+func NewLayerNormModel[T mat32.Float](hiddenSizes []int) Model[T] {
+	model := Model[T]{}
+	ones := func(n int) *mat32.MatOf[T] {
+		m := mat32.NewMatOf[T](n, 1)
+		for i := range m.W {
+			m.W[i] = 1
+		}
+		return m
+	}
+
+	for d := 0; d < len(hiddenSizes); d++ { // loop over depths
+		hiddenSize := hiddenSizes[d]
+		ds := strconv.Itoa(d)
+		model["gi"+ds] = ones(hiddenSize)
+		model["gf"+ds] = ones(hiddenSize)
+		model["go"+ds] = ones(hiddenSize)
+		model["gc"+ds] = ones(hiddenSize)
+		// cell state
+		model["gs"+ds] = ones(hiddenSize)
+		model["bs"+ds] = mat32.NewMatOf[T](hiddenSize, 1)
+	}
 
 	return model
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/getlantern/errors"
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+	"gopkg.in/urfave/cli.v1"
+)
+
+/*
+precisionFlag picks how many bits each weight gets, 32 or 64. float32 is the
+fast one for training; float64 is for gradient checks and small research runs.
+*/
+var precisionFlag = cli.IntFlag{
+	Name:  "precision",
+	Value: 32,
+	Usage: "(optional) Float `bits` per weight, 32 or 64. A loaded model keeps the precision it was saved with unless this is set.",
+}
+
+/*
+precisionBits is the bit size of T.
+*/
+func precisionBits[T mat32.Float]() int {
+	var t T
+	if _, ok := any(t).(float32); ok {
+		return 32
+	}
+	return 64
+}
+
+/*
+savedPrecision peeks at the precision a model was saved with. Models from
+before there was a choice are float32.
+*/
+func savedPrecision(loadFilepath string) (int, error) {
+	s, err := ioutil.ReadFile(loadFilepath)
+	if err != nil {
+		return 0, err
+	}
+	var saved struct{ Precision int }
+	err = json.Unmarshal(s, &saved)
+	if err != nil {
+		return 0, err
+	}
+	if saved.Precision == 0 {
+		return 32, nil
+	}
+	return saved.Precision, nil
+}
+
+/*
+resolvePrecision works out the precision a command should run in: --precision
+when it is given, otherwise the loaded model's, otherwise the default.
+*/
+func resolvePrecision(c *cli.Context, loadFilepath string) (int, error) {
+	bits := c.Int("precision")
+	if !c.IsSet("precision") && loadFilepath != "" {
+		var err error
+		bits, err = savedPrecision(loadFilepath)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if bits != 32 && bits != 64 {
+		return 0, errors.New("--precision must be 32 or 64")
+	}
+	return bits, nil
+}
+
+/*
+loadState reads a saved model into a TrainingState of T. The weights are
+plain JSON numbers, so a model saved in either precision loads into either,
+which is all converting between them takes.
+*/
+func loadState[T mat32.Float](loadFilepath string) (*TrainingState[T], error) {
+	s, err := ioutil.ReadFile(loadFilepath)
+	if err != nil {
+		return nil, err
+	}
+	state := &TrainingState[T]{}
+	err = json.Unmarshal(s, state)
+	if err != nil {
+		fmt.Println("state=", state)
+		return nil, err
+	}
+	state.unpackHalfModel()
+	state.migrateModel()
+	state.Precision = precisionBits[T]()
+	err = state.initRandom()
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/getlantern/errors"
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+)
+
+/*
+evalBatchSize is how many sentences Perplexity runs together.
+*/
+const evalBatchSize = 32
+
+/*
+quantized tells whether the state is an int8 model, which can only do
+inference.
+*/
+func (state *TrainingState[T]) quantized() bool {
+	return len(state.QuantModel) > 0
+}
+
+/*
+Quantize moves every weight matrix of the Model to int8 in QuantModel. The
+biases and layer norm gains are tiny, so they stay as they are.
+*/
+func (state *TrainingState[T]) Quantize() {
+	if state.QuantModel == nil {
+		state.QuantModel = make(map[string]*mat32.QuantMat)
+	}
+	for k, m := range state.Model {
+		if m.ColumnCount == 1 {
+			continue
+		}
+		state.QuantModel[k] = mat32.Quantize(m)
+		delete(state.Model, k)
+	}
+}
+
+/*
+mul multiplies the Model matrix `key` by m - its int8 version, when the
+model is quantized.
+*/
+func (state *TrainingState[T]) mul(key string, m *mat32.MatOf[T]) *mat32.MatOf[T] {
+	if q, ok := state.QuantModel[key]; ok {
+		return state.QuantMul(q, m)
+	}
+	return state.Mul(state.Model[key], m)
+}
+
+/*
+mulBlock is mul for the rows [rowFrom, rowTo) and columns [colFrom, colTo) of
+the Model matrix `key`.
+*/
+func (state *TrainingState[T]) mulBlock(key string, rowFrom int, rowTo int, colFrom int, colTo int, m *mat32.MatOf[T]) *mat32.MatOf[T] {
+	if q, ok := state.QuantModel[key]; ok {
+		return state.QuantMulBlock(q, rowFrom, rowTo, colFrom, colTo, m)
+	}
+	return state.MulBlock(state.Model[key], rowFrom, rowTo, colFrom, colTo, m)
+}
+
+/*
+embed plucks the letter embeddings of ixs, a column each.
+*/
+func (state *TrainingState[T]) embed(ixs []int) *mat32.MatOf[T] {
+	if q, ok := state.QuantModel["Wil"]; ok {
+		return state.QuantRowsPluck(q, ixs)
+	}
+	return state.RowsPluck(state.Model["Wil"], ixs)
+}
+
+/*
+Perplexity is the perplexity of the model on sents, measured without
+training on them. Sentences too short to predict anything are skipped.
+*/
+func (state *TrainingState[T]) Perplexity(sents []string) float64 {
+	var usable []string
+	for _, sent := range sents {
+		if utf8.RuneCountInString(sent) > 1 {
+			usable = append(usable, sent)
+		}
+	}
+	var log2ppl float64
+	predicted := 0
+	for from := 0; from < len(usable); from += evalBatchSize {
+		batch := usable[from:min(from+evalBatchSize, len(usable))]
+		cost := state.Evaluate(batch)
+		n := 0
+		for _, sent := range batch {
+			n += utf8.RuneCountInString(sent) - 1
+		}
+		// Cost.Ppl is 2 to the mean log2 loss per predicted letter
+		log2ppl += math.Log2(cost.Ppl) * float64(n)
+		predicted += n
+	}
+	return math.Pow(2, log2ppl/float64(predicted))
+}
+
+/*
+quantize saves an int8 copy of a model, and with a held-out file, reports
+how much perplexity quantizing cost.
+*/
+func quantize[T mat32.Float](loadFilepath string, saveFilepath string, heldoutFilepath string) error {
+	state, err := loadState[T](loadFilepath)
+	if err != nil {
+		return err
+	}
+	if state.quantized() {
+		return errors.New("The model is already quantized")
+	}
+
+	var heldout []string
+	var floatPpl float64
+	if heldoutFilepath != "" {
+		text, err := readFileContents(heldoutFilepath)
+		if err != nil {
+			return err
+		}
+		heldout = strings.Split(text, "\n")
+		floatPpl = state.Perplexity(heldout)
+	}
+
+	state.Quantize()
+	fmt.Println("Quantized", len(state.QuantModel), "weight matrices to int8")
+	if heldout != nil {
+		quantPpl := state.Perplexity(heldout)
+		fmt.Println("held-out perplexity")
+		fmt.Println("  float=", floatPpl)
+		fmt.Println("  int8= ", quantPpl)
+		fmt.Printf("  delta= %+g (%+.2f%%)\n", quantPpl-floatPpl, 100*(quantPpl-floatPpl)/floatPpl)
+	}
+
+	jsonState, err := state.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileContents(saveFilepath, jsonState)
+}
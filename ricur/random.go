@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+var randomSeedFlag = cli.Uint64Flag{
+	Name:  "random-seed",
+	Usage: "(optional) Start the random stream from this `uint64`, so the run can be reproduced. A loaded model otherwise continues the stream it was saved with.",
+}
+
+/*
+randomSeed starts the random stream over when hasRandomSeed is set.
+*/
+var randomSeed uint64
+var hasRandomSeed = false
+
+/*
+setRandomSeed is the Before hook of the commands that take --random-seed.
+*/
+func setRandomSeed(c *cli.Context) error {
+	randomSeed = c.Uint64("random-seed")
+	hasRandomSeed = c.IsSet("random-seed")
+	return nil
+}
+
+/*
+Seed starts the state's random stream over from seed. Every random number of
+a run - initialization, sentence selection, dropout and sampling - comes
+from it.
+*/
+func (state *TrainingState[T]) Seed(seed uint64) {
+	state.pcg = rand.NewPCG(seed, seed)
+	state.Rand = rand.New(state.pcg)
+}
+
+/*
+initRandom gets the random stream going: from --random-seed when it was
+given, otherwise from where a loaded state left off, otherwise from the
+clock.
+*/
+func (state *TrainingState[T]) initRandom() error {
+	if hasRandomSeed {
+		state.Seed(randomSeed)
+		return nil
+	}
+	if len(state.RandomState) > 0 {
+		state.pcg = &rand.PCG{}
+		err := state.pcg.UnmarshalBinary(state.RandomState)
+		if err != nil {
+			return err
+		}
+		state.Rand = rand.New(state.pcg)
+		return nil
+	}
+	state.Seed(uint64(time.Now().UnixNano()))
+	return nil
+}
+
+/*
+saveRandom records where the random stream is, for the state to be saved.
+*/
+func (state *TrainingState[T]) saveRandom() (err error) {
+	if state.pcg == nil {
+		return nil
+	}
+	state.RandomState, err = state.pcg.MarshalBinary()
+	return err
+}
+
+/*
+randi makes random integers between two integers
+*/
+func randi(r *rand.Rand, low int, hi int) int {
+	a := float64(low)
+	b := float64(hi)
+	return int(math.Floor(r.Float64()*(b-a) + a))
+}
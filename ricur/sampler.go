@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+	"gopkg.in/urfave/cli.v1"
+)
+
+/*
+samplerFlags pick how `ricur sample` chooses each next letter.
+*/
+var samplerFlags = []cli.Flag{
+	cli.Float64Flag{
+		Name:  "temperature",
+		Value: 1,
+		Usage: "(optional) Divides the network's output before sampling. Below 1 is safer and more repetitive, above 1 more surprising. 0 is the same as --greedy.",
+	},
+	cli.IntFlag{
+		Name:  "top-k",
+		Usage: "(optional) Only sample from the `k` most likely letters. 0 for all of them.",
+	},
+	cli.Float64Flag{
+		Name:  "top-p",
+		Usage: "(optional) Nucleus sampling: only sample from the fewest most likely letters that hold this much `probability`, like 0.9. 0 for all of them.",
+	},
+	cli.Float64Flag{
+		Name:  "typical",
+		Usage: "(optional) Typical sampling: only sample from the letters closest to the expected surprise that hold this much `probability`, like 0.9. 0 for all of them.",
+	},
+	cli.BoolFlag{
+		Name:  "greedy",
+		Usage: "(optional) Always take the most likely letter instead of sampling. Cannot be used with --top-k, --top-p or --typical.",
+	},
+}
+
+/*
+samplerFromFlags reads samplerFlags.
+*/
+func samplerFromFlags(c *cli.Context) (mat32.Sampler, error) {
+	sampler := mat32.Sampler{
+		Temperature: c.Float64("temperature"),
+		TopK:        c.Int("top-k"),
+		TopP:        c.Float64("top-p"),
+		Typical:     c.Float64("typical"),
+		Greedy:      c.Bool("greedy"),
+	}
+	return sampler, sampler.Validate()
+}
@@ -5,20 +5,20 @@ import "github.com/ruffrey/recurrent-nn-char-go/mat32"
 /*
 Solver is a solver
 */
-type Solver struct {
-	DecayRate float32
-	SmoothEPS float32
-	StepCache map[string]*mat32.Mat
+type Solver[T mat32.Float] struct {
+	DecayRate T
+	SmoothEPS T
+	StepCache map[string]*mat32.MatOf[T]
 }
 
 /*
 NewSolver instantiates a Solver
 */
-func NewSolver() *Solver {
-	s := &Solver{
+func NewSolver[T mat32.Float]() *Solver[T] {
+	s := &Solver[T]{
 		DecayRate: 0.999,
 		SmoothEPS: 1e-8,
-		StepCache: make(map[string]*mat32.Mat),
+		StepCache: make(map[string]*mat32.MatOf[T]),
 	}
 	return s
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/getlantern/errors"
+	"github.com/ruffrey/recurrent-nn-char-go/mat32"
+	"gopkg.in/urfave/cli.v1"
+)
+
+/*
+storageFlag picks how the weights of a model are stored. The math is always
+done at --precision; half precision storage just rounds the weights to 16
+bits, which makes saved models a fraction of the size.
+*/
+var storageFlag = cli.StringFlag{
+	Name:  "storage",
+	Value: "float",
+	Usage: "(optional) How weights are stored: `format` float, bf16 or f16. A loaded model keeps the storage it was saved with unless this is set.",
+}
+
+/*
+storage is how --storage says to store weights, "" for full precision. It
+only applies when hasStorage is set; otherwise a loaded model keeps its own.
+*/
+var storage string
+var hasStorage = false
+
+/*
+readStorageFlag is the Before hook of the commands that take --storage.
+*/
+func readStorageFlag(c *cli.Context) error {
+	hasStorage = c.IsSet("storage")
+	storage = c.String("storage")
+	if storage == "float" {
+		storage = ""
+		return nil
+	}
+	if _, err := mat32.ParseHalfFormat(storage); err != nil {
+		return errors.New("--storage must be float, bf16 or f16")
+	}
+	return nil
+}
+
+/*
+storageName is storage as --storage spells it.
+*/
+func storageName(storage string) string {
+	if storage == "" {
+		return "float"
+	}
+	return storage
+}
+
+/*
+marshal is the JSON of the state, with the Model in HalfModel when it is
+stored at half precision.
+*/
+func (state *TrainingState[T]) marshal() ([]byte, error) {
+	if state.Storage == "" {
+		return json.Marshal(state)
+	}
+	format := mat32.HalfFormat(state.Storage)
+	model := state.Model
+	state.HalfModel = make(map[string]*mat32.HalfMat, len(model))
+	for k, m := range model {
+		state.HalfModel[k] = mat32.ToHalfMat(m, format)
+	}
+	state.Model = nil
+	defer func() {
+		state.Model = model
+		state.HalfModel = nil
+	}()
+	return json.Marshal(state)
+}
+
+/*
+unpackHalfModel expands a loaded HalfModel back into the Model.
+*/
+func (state *TrainingState[T]) unpackHalfModel() {
+	if state.HalfModel == nil {
+		return
+	}
+	state.Model = Model[T]{}
+	for k, h := range state.HalfModel {
+		state.Model[k] = mat32.FromHalfMat[T](h)
+	}
+	state.HalfModel = nil
+}
+
+/*
+setStorage switches the state to storage, rounding the weights to it.
+*/
+func (state *TrainingState[T]) setStorage(storage string) {
+	state.Storage = storage
+	if storage == "" {
+		return
+	}
+	for _, m := range state.Model {
+		mat32.RoundHalf(m.W, mat32.HalfFormat(storage))
+	}
+}
+
+/*
+initMasterWeights starts training a half precision model the usual way for
+one: the network computes with the weights rounded to half precision, but
+the solver updates full precision master copies of them, so steps too small
+to show up at half precision still add up.
+*/
+func (state *TrainingState[T]) initMasterWeights() {
+	state.master = nil
+	if state.Storage == "" {
+		return
+	}
+	state.master = make(map[*mat32.MatOf[T]][]T, len(state.Model))
+	for _, m := range state.Model {
+		state.master[m] = append([]T(nil), m.W...)
+	}
+	state.setStorage(state.Storage)
+}
+
+/*
+withMasterWeights runs a solver step on the master weights, then rounds them
+into the weights the network computes with. Without master weights it just
+runs step.
+*/
+func (state *TrainingState[T]) withMasterWeights(step func()) {
+	if state.master == nil {
+		step()
+		return
+	}
+	for m, w := range state.master {
+		copy(m.W, w)
+	}
+	step()
+	format := mat32.HalfFormat(state.Storage)
+	for m, w := range state.master {
+		copy(w, m.W)
+		mat32.RoundHalf(m.W, format)
+	}
+}
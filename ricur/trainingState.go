@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"math"
+	"math/rand/v2"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,23 +16,37 @@ import (
 TrainingState is the representation of the training data which gets saved or loaded
 to disk between sessions.
 */
-type TrainingState struct {
-	mat32.Graph    `json:"-"`
-	HiddenSizes    []int
-	Model          Model
-	Solver         Solver
-	LetterToIndex  map[string]int
-	IndexToLetter  map[int]string
-	Vocab          []string
-	PerplexityList []float64 `json:"-"`
-	HiddenPrevs    []*mat32.Mat
-	CellPrevs      []*mat32.Mat
-	InputSize      int
-	OutputSize     int
+type TrainingState[T mat32.Float] struct {
+	mat32.GraphOf[T]    `json:"-"`
+	HiddenSizes         []int
+	LayerNormLSTM       bool                       // layer normalized LSTM cells
+	CellActivation      string                     // squashes the cell state into the hidden state, tanh when empty
+	CandidateActivation string                     // of the candidate cell write, tanh when empty
+	Precision           int                        // bits the math is done in, 32 or 64
+	RandomState         []byte                     // where the random stream is, so a resumed run continues it
+	Storage             string                     // how the weights are stored: "" for full precision, or bf16 or f16
+	Model               Model[T]                   `json:",omitempty"`
+	HalfModel           map[string]*mat32.HalfMat  `json:",omitempty"` // the Model when it is saved at half precision
+	QuantModel          map[string]*mat32.QuantMat `json:",omitempty"` // int8 weight matrices of a quantized model, which only does inference
+	Solver              Solver[T]
+	LetterToIndex       map[string]int
+	IndexToLetter       map[int]string
+	Vocab               []string
+	PerplexityList      []float64 `json:"-"`
+	GradNormList        []float64 `json:"-"` // gradient norm of each step, before clipping
+	HiddenPrevs         []*mat32.MatOf[T]
+	CellPrevs           []*mat32.MatOf[T]
+	InputSize           int
+	OutputSize          int
 
 	// the following do not need to be persisted between training sessions
 	EpochSize     int
 	lastSaveEpoch float64
+	pcg           *rand.PCG               // behind Rand
+	master        map[*mat32.MatOf[T]][]T // full precision weights behind a half precision Model
+	cellAct       mat32.Activation[T]
+	candidateAct  mat32.Activation[T]
+	opByOp        bool     // build the LSTM out of ops even when a fused cell would do
 	DataSentences []string `json:"-"`
 	TickIterator  int      `json:"-"`
 }
@@ -38,7 +54,7 @@ type TrainingState struct {
 /*
 InitVocab helps initialize this instance's vocab array.
 */
-func (state *TrainingState) InitVocab(sents []string, countThreshold int) {
+func (state *TrainingState[T]) InitVocab(sents []string, countThreshold int) {
 	// go over all characters and keep track of all unique ones seen
 	txt := strings.Join(sents, "")
 
@@ -62,8 +78,14 @@ func (state *TrainingState) InitVocab(sents []string, countThreshold int) {
 	// NOTE: start at one because we will have START and END tokens!
 	// that is, START token will be index 0 in model letter vectors
 	// and END token will be index 0 in the next character softmax
-	q := 1
+	// in a fixed order, so a seeded run gets the same vocab every time
+	chars := make([]string, 0, len(d))
 	for ch := range d {
+		chars = append(chars, ch)
+	}
+	sort.Strings(chars)
+	q := 1
+	for _, ch := range chars {
 		if len(ch) > 1 {
 			fmt.Println("Dropping char due to size-bounds issue:", ch)
 			continue
@@ -85,20 +107,23 @@ func (state *TrainingState) InitVocab(sents []string, countThreshold int) {
 /*
 InitModel inits its own Model
 */
-func (state *TrainingState) InitModel() {
+func (state *TrainingState[T]) InitModel() {
 	// letter embedding vectors
-	tempModel := Model{}
+	tempModel := Model[T]{}
 	// Wil is a Letter Weight x sequence length matrix,
 	// so
-	tempModel["Wil"] = mat32.RandMat(state.InputSize, sequenceLength, 0.08)
+	tempModel["Wil"] = mat32.InitMatOf[T](state.Rand, state.InputSize, sequenceLength, initializers.Input)
 
-	lstm := NewLSTMModel(sequenceLength, state.HiddenSizes, state.OutputSize)
+	lstm := NewLSTMModel[T](state.Rand, initializers, sequenceLength, state.HiddenSizes, state.OutputSize)
 	utilAddToModel(tempModel, lstm)
+	if state.LayerNormLSTM {
+		utilAddToModel(tempModel, NewLayerNormModel[T](state.HiddenSizes))
+	}
 
 	state.Model = tempModel
 }
 
-func utilAddToModel(modelto Model, modelfrom Model) {
+func utilAddToModel[T mat32.Float](modelto Model[T], modelfrom Model[T]) {
 	for k := range modelfrom {
 		// copy over the pointer but change the key to use the append
 		modelto[k] = modelfrom[k]
@@ -108,112 +133,100 @@ func utilAddToModel(modelto Model, modelfrom Model) {
 /*
 ForwardLSTM does forward propagation for a single tick of LSTM. Will be called in a loop.
 
-x is 1D column vector with observation
+x is 1D column vector with observation, or one column per sentence of a batch
 prev is a struct containing hidden and cell from previous iteration
 */
-func (state *TrainingState) ForwardLSTM(hiddenSizes []int, x *mat32.Mat, prev *CellMemory) *CellMemory {
+func (state *TrainingState[T]) ForwardLSTM(hiddenSizes []int, x *mat32.MatOf[T], prev CellMemory[T]) CellMemory[T] {
 
 	// initialize when not yet initialized. we know there will always be hidden layers.
 	if len(prev.Hidden) == 0 {
 		// reset these
-		state.HiddenPrevs = make([]*mat32.Mat, len(hiddenSizes))
-		state.CellPrevs = make([]*mat32.Mat, len(hiddenSizes))
+		state.HiddenPrevs = state.Mats(len(hiddenSizes))
+		state.CellPrevs = state.Mats(len(hiddenSizes))
 		for s := 0; s < len(hiddenSizes); s++ {
-			state.HiddenPrevs[s] = mat32.NewMat(hiddenSizes[s], 1)
-			state.CellPrevs[s] = mat32.NewMat(hiddenSizes[s], 1)
+			state.HiddenPrevs[s] = state.NewMat(hiddenSizes[s], x.ColumnCount)
+			state.CellPrevs[s] = state.NewMat(hiddenSizes[s], x.ColumnCount)
 		}
 	} else {
 		state.HiddenPrevs = prev.Hidden
 		state.CellPrevs = prev.Cell
 	}
 
-	var hidden []*mat32.Mat
-	var cell []*mat32.Mat
-	var inputVector *mat32.Mat
-	var hiddenPrev *mat32.Mat
-	var cellPrev *mat32.Mat
-
-	// Parallizing this hot path is tricky because it
-	// relies on the previous array value, so the best
-	// we can do is compute a few parts inside the loop in parallel
-	var inputGate *mat32.Mat
-	var forgetGate *mat32.Mat
-	var outputGate *mat32.Mat
-	var cellWrite *mat32.Mat
-	var wg sync.WaitGroup
+	hidden := state.Mats(len(hiddenSizes))
+	cell := state.Mats(len(hiddenSizes))
+	var inputVector *mat32.MatOf[T]
+	var hiddenPrev *mat32.MatOf[T]
+	var cellPrev *mat32.MatOf[T]
+
+	cellAct, candidateAct := state.activations()
 	for d := 0; d < len(hiddenSizes); d++ {
 		if d == 0 {
 			inputVector = x
 		} else {
-			inputVector = hidden[d-1]
+			inputVector = state.Dropout(hidden[d-1], T(dropout))
 		}
 		hiddenPrev = state.HiddenPrevs[d]
 		cellPrev = state.CellPrevs[d]
 
 		// ds is the index but as a string
 		ds := strconv.Itoa(d)
-
-		// send 4 jobs to the worker, when 4 come back, done.
-		wg.Add(4)
-		// input gate
-		go (func() {
-			if simplified {
-				h1 := state.Mul(state.Model["Wih"+ds], hiddenPrev)
-				inputGate = state.Sigmoid(h1)
-				wg.Done()
-				return
+		b := state.Model["b"+ds]
+
+		// the plain and simplified LSTM cells are a single op
+		if state.fusedLSTM() {
+			var hiddenD, cellD *mat32.MatOf[T]
+			if Wq, ok := state.QuantModel["W"+ds]; ok {
+				cellOp := state.QuantLSTMCell
+				if simplified {
+					cellOp = state.QuantSimplifiedLSTMCell
+				}
+				hiddenD, cellD = cellOp(Wq, b, inputVector, hiddenPrev, cellPrev)
+			} else {
+				cellOp := state.LSTMCell
+				if simplified {
+					cellOp = state.SimplifiedLSTMCell
+				}
+				hiddenD, cellD = cellOp(state.Model["W"+ds], b, inputVector, hiddenPrev, cellPrev)
 			}
-			h0 := state.Mul(state.Model["Wix"+ds], inputVector)
-			h1 := state.Mul(state.Model["Wih"+ds], hiddenPrev)
-			add1 := state.Add(h0, h1)
-			add2 := state.Add(add1, state.Model["bi"+ds])
-			inputGate = state.Sigmoid(add2)
-			wg.Done()
-		})()
-
-		// forget gate
-		go (func() {
-			if simplified {
-				h3 := state.Mul(state.Model["Wfh"+ds], hiddenPrev)
-				forgetGate = state.Sigmoid(h3)
-				wg.Done()
-				return
+			hidden[d] = hiddenD
+			cell[d] = cellD
+			continue
+		}
+
+		// otherwise it is built out of ops: all four gates' summed inputs
+		// come out of one multiply by the stacked weights, then split up.
+		hiddenSize := hiddenSizes[d]
+		xh := state.ConcatRows(inputVector, hiddenPrev)
+		var sums []*mat32.MatOf[T]
+		if simplified {
+			// the gates only see the previous hidden state, so only the
+			// hPrev columns of their rows multiply it, and only the cell
+			// write's rows multiply all of [x; hPrev]
+			nx := inputVector.RowCount
+			gates := state.mulBlock("W"+ds, 0, 3*hiddenSize, nx, nx+hiddenSize, hiddenPrev)
+			sums = state.Mats(4)
+			copy(sums, state.SplitRows(gates, hiddenSize, hiddenSize, hiddenSize))
+			sums[3] = state.mulBlock("W"+ds, 3*hiddenSize, 4*hiddenSize, 0, nx+hiddenSize, xh)
+		} else {
+			sums = state.SplitRows(state.mul("W"+ds, xh), hiddenSize, hiddenSize, hiddenSize, hiddenSize)
+		}
+		biases := state.SplitRows(b, hiddenSize, hiddenSize, hiddenSize, hiddenSize)
+
+		// gateSum finishes a gate's summed inputs, adding its bias - or with
+		// layer norm, normalizing them with the bias as the shift.
+		gateSum := func(gate int, gain string) *mat32.MatOf[T] {
+			if state.LayerNormLSTM {
+				return state.LayerNorm(sums[gate], state.Model[gain+ds], biases[gate])
 			}
-			h2 := state.Mul(state.Model["Wfx"+ds], inputVector)
-			h3 := state.Mul(state.Model["Wfh"+ds], hiddenPrev)
-			add3 := state.Add(h2, h3)
-			add4 := state.Add(add3, state.Model["bf"+ds])
-			forgetGate = state.Sigmoid(add4)
-			wg.Done()
-		})()
-
-		// output gate
-		go (func() {
-			if simplified {
-				h5 := state.Mul(state.Model["Woh"+ds], hiddenPrev)
-				outputGate = state.Sigmoid(h5)
-				wg.Done()
-				return
+			if simplified && gate < 3 {
+				return sums[gate] // no bias either
 			}
-			h4 := state.Mul(state.Model["Wox"+ds], inputVector)
-			h5 := state.Mul(state.Model["Woh"+ds], hiddenPrev)
-			add45 := state.Add(h4, h5)
-			add45bods := state.Add(add45, state.Model["bo"+ds])
-			outputGate = state.Sigmoid(add45bods)
-			wg.Done()
-		})()
-
-		// write operation on cells
-		go (func() {
-			h6 := state.Mul(state.Model["Wcx"+ds], inputVector)
-			h7 := state.Mul(state.Model["Wch"+ds], hiddenPrev)
-			add67 := state.Add(h6, h7)
-			add67bcds := state.Add(add67, state.Model["bc"+ds])
-			cellWrite = state.Tanh(add67bcds)
-			wg.Done()
-		})()
-
-		wg.Wait()
+			return state.Add(sums[gate], biases[gate])
+		}
+		inputGate := state.Sigmoid(gateSum(0, "gi"))
+		forgetGate := state.Sigmoid(gateSum(1, "gf"))
+		outputGate := state.Sigmoid(gateSum(2, "go"))
+		cellWrite := candidateAct(&state.GraphOf, gateSum(3, "gc"))
 
 		// compute new cell activation
 		retainCell := state.Eltmul(forgetGate, cellPrev) // what do we keep from cell
@@ -221,33 +234,119 @@ func (state *TrainingState) ForwardLSTM(hiddenSizes []int, x *mat32.Mat, prev *C
 		cellD := state.Add(retainCell, writeCell)        // new cell contents
 
 		// compute hidden state as gated, saturated cell activations
-		tahncellD := state.Tanh(cellD)
+		cellOut := cellD
+		if state.LayerNormLSTM {
+			cellOut = state.LayerNorm(cellD, state.Model["gs"+ds], state.Model["bs"+ds])
+		}
+		tahncellD := cellAct(&state.GraphOf, cellOut)
 		hiddenD := state.Eltmul(outputGate, tahncellD)
 
-		hidden = append(hidden, hiddenD)
-		cell = append(cell, cellD)
+		hidden[d] = hiddenD
+		cell[d] = cellD
 	}
 
 	// one decoder to outputs at end
-	whdlasthidden := state.Mul(state.Model["Whd"], hidden[len(hidden)-1])
+	lastHidden := state.Dropout(hidden[len(hidden)-1], T(dropout))
+	whdlasthidden := state.mul("Whd", lastHidden)
 	output := state.Add(whdlasthidden, state.Model["bd"])
 
 	// return cell memory, hidden representation and output
-	return &CellMemory{
+	return CellMemory[T]{
 		Hidden: hidden,
 		Cell:   cell,
 		Output: output,
 	}
 }
 
+/*
+fusedLSTM tells whether the LSTM cells are the kind LSTMCell (or
+SimplifiedLSTMCell) computes: tanh activations and no layer norm.
+*/
+func (state *TrainingState[T]) fusedLSTM() bool {
+	isTanh := func(name string) bool { return name == "" || name == "tanh" }
+	return !state.opByOp && !state.LayerNormLSTM && isTanh(state.CellActivation) && isTanh(state.CandidateActivation)
+}
+
+/*
+migrateModel brings a model saved before the LSTM gates were stacked into
+the stacked layout, along with its solver's step cache.
+*/
+func (state *TrainingState[T]) migrateModel() {
+	for d := range state.HiddenSizes {
+		ds := strconv.Itoa(d)
+		if !stackLSTM(state.Model, ds) {
+			continue
+		}
+		fmt.Println("Stacked the LSTM gate weights of layer", ds)
+		if !stackLSTM(Model[T](state.Solver.StepCache), ds) {
+			// the step cache for the stacked weights starts over
+			for _, gate := range lstmGates {
+				for _, k := range gate {
+					delete(state.Solver.StepCache, k+ds)
+				}
+			}
+		}
+	}
+}
+
+/*
+activations looks up the model's cell and candidate activations once.
+*/
+func (state *TrainingState[T]) activations() (cell mat32.Activation[T], candidate mat32.Activation[T]) {
+	if state.cellAct == nil {
+		state.cellAct = mustActivation[T](state.CellActivation)
+		state.candidateAct = mustActivation[T](state.CandidateActivation)
+	}
+	return state.cellAct, state.candidateAct
+}
+
+func mustActivation[T mat32.Float](name string) mat32.Activation[T] {
+	if name == "" {
+		name = "tanh"
+	}
+	f, err := mat32.ActivationOf[T](name)
+	mat32.Assert(err == nil, fmt.Sprint(err))
+	return f
+}
+
+/*
+GradNorm is the L2 norm of the gradients of every Model matrix together.
+*/
+func (state *TrainingState[T]) GradNorm() float64 {
+	var sum float64
+	for _, m := range state.Model {
+		add := func(dw []T) {
+			for _, v := range dw {
+				sum += float64(v) * float64(v)
+			}
+		}
+		if rows, sparse := state.TouchedRows(m); sparse {
+			d := m.ColumnCount
+			for _, r := range rows {
+				add(m.DW[r*d : r*d+d])
+			}
+		} else {
+			add(m.DW)
+		}
+	}
+	return math.Sqrt(sum)
+}
+
 /*
 StepSolver does a param update on the model, increasing or decreasing the weights,
 and clipping the derivative first if necessary.
 
+Embedding tables like Wil, which the graph only reads by plucking rows, just
+have their plucked rows updated. Their other rows keep their step cache and
+skip regularization until they are next used, which is what makes a large
+vocabulary affordable. A table some other op also read gets a dense update.
+
 stepSize is the learningRate
 regc is regularization
+gradScale multiplies every derivative before anything else, to average
+accumulated gradients or to clip their norm
 */
-func (state *TrainingState) StepSolver(solver *Solver, stepSize float32, regc float32, clipval float32) {
+func (state *TrainingState[T]) StepSolver(solver *Solver[T], stepSize T, regc T, clipval T, gradScale T) {
 	// perform parameter update
 	var wg sync.WaitGroup
 
@@ -256,7 +355,7 @@ func (state *TrainingState) StepSolver(solver *Solver, stepSize float32, regc fl
 	for key, mod := range state.Model {
 		_, hasKey := solver.StepCache[key]
 		if !hasKey {
-			solver.StepCache[key] = mat32.NewMat(mod.RowCount, mod.ColumnCount)
+			solver.StepCache[key] = mat32.NewMatOf[T](mod.RowCount, mod.ColumnCount)
 		}
 	}
 
@@ -267,43 +366,53 @@ func (state *TrainingState) StepSolver(solver *Solver, stepSize float32, regc fl
 		// Having this lower down in the for loop nest did not seem to
 		// speed things up, due to increased overhead of tracking
 		// goroutines by the runtime.
-		go (func(k string, m *mat32.Mat) {
-			i := 0
-			n := len(m.W)
-			for ; i < n; i++ {
-				// rmsprop adaptive learning rate
-				mdwi := m.DW[i]
-				solver.StepCache[k].W[i] = solver.StepCache[k].W[i]*solver.DecayRate + (1.0-solver.DecayRate)*mdwi*mdwi
-
-				// gradient clip
-				if mdwi > clipval {
-					mdwi = clipval
-				}
-				if mdwi < -clipval {
-					mdwi = -clipval
+		go (func(k string, m *mat32.MatOf[T]) {
+			cache := solver.StepCache[k]
+			update := func(from int, to int) {
+				for i := from; i < to; i++ {
+					// rmsprop adaptive learning rate
+					mdwi := m.DW[i] * gradScale
+					cache.W[i] = cache.W[i]*solver.DecayRate + (1.0-solver.DecayRate)*mdwi*mdwi
+
+					// gradient clip
+					if mdwi > clipval {
+						mdwi = clipval
+					}
+					if mdwi < -clipval {
+						mdwi = -clipval
+					}
+
+					// update (and regularize)
+					kwi := cache.W[i]
+					sqrtSumEPS := T(math.Sqrt(float64(kwi + solver.SmoothEPS)))
+					m.W[i] += -stepSize*mdwi/sqrtSumEPS - regc*m.W[i]
+					m.DW[i] = 0 // reset gradients for next iteration
 				}
+			}
 
-				// update (and regularize)
-				kwi := solver.StepCache[k].W[i]
-				sqrtSumEPS := float32(math.Sqrt(float64(kwi + solver.SmoothEPS)))
-				m.W[i] += -stepSize*mdwi/sqrtSumEPS - regc*m.W[i]
-				m.DW[i] = 0 // reset gradients for next iteration
+			if rows, sparse := state.TouchedRows(m); sparse {
+				d := m.ColumnCount
+				for _, r := range rows {
+					update(r*d, r*d+d)
+				}
+			} else {
+				update(0, len(m.W))
 			}
 			wg.Done()
 		})(key, mod)
 	}
 	wg.Wait()
+	state.ClearTouchedRows()
 }
 
 /*
 PredictSentence creates a prediction based on the current training state. similar to cost function.
+Each letter is picked the way sampler says.
 */
-func (state *TrainingState) PredictSentence(maxCharsGenerate int, seedString string) (s string) {
+func (state *TrainingState[T]) PredictSentence(maxCharsGenerate int, seedString string, sampler mat32.Sampler) (s string) {
 	state.NeedsBackprop = false // temporary but do not lose functions
-	var prev *CellMemory
-	initial := &CellMemory{}
-	prev = initial
-	var lh *CellMemory
+	var prev CellMemory[T]
+	var lh CellMemory[T]
 	seedIndex := 0
 	seed := strings.Split(seedString, "")
 
@@ -326,18 +435,16 @@ func (state *TrainingState) PredictSentence(maxCharsGenerate int, seedString str
 
 		lh = state.ForwardLSTM(
 			state.HiddenSizes,
-			state.RowPluck(state.Model["Wil"], ixSource),
+			state.embed([]int{ixSource}),
 			prev,
 		)
 		prev = lh
 
 		// sample predicted letter
 		logrithmicProbabilities := lh.Output
-		probs := mat32.Softmax(logrithmicProbabilities)
-
-		ixSource = mat32.SampleArgmaxI(probs.W)
+		ixSource = mat32.Sample(state.Rand, sampler, logrithmicProbabilities.W)
 
-		if ixSource == 0 || ixSource == len(probs.W) {
+		if ixSource == 0 || ixSource == len(logrithmicProbabilities.W) {
 			break // start or end token predicted, break out
 		}
 		if len(s) > maxCharsGenerate {
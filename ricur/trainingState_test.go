@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func closeTo(got float64, want float64) bool {
+	return math.Abs(got-want) <= 1e-6+1e-3*math.Abs(want)
+}
+
+/*
+scaleUp multiplies the weights of state by 10, away from the small values
+they start at, so the hidden state matters to the cost.
+*/
+func scaleUp(state *TrainingState[float32]) {
+	for _, m := range state.Model {
+		for i := range m.W {
+			m.W[i] *= 10
+		}
+	}
+}
+
+/*
+TestUnfusedLSTM checks the LSTM built out of ops against the fused cell ops,
+plain and simplified: the cost, every gradient, and the cost once quantized.
+*/
+func TestUnfusedLSTM(t *testing.T) {
+	defer func(old bool) { simplified = old }(simplified)
+	sents := []string{testSentence, "the lazy dog"}
+	for _, simple := range []bool{false, true} {
+		simplified = simple
+		fused, _ := newTestState()
+		ops, _ := newTestState()
+		ops.opByOp = true
+		scaleUp(fused)
+		scaleUp(ops)
+		if ops.fusedLSTM() {
+			t.Fatal("the state still uses the fused cell")
+		}
+
+		want := fused.CostFunctionBatch(sents)
+		fused.Backward()
+		got := ops.CostFunctionBatch(sents)
+		ops.Backward()
+		if !closeTo(got.Cost, want.Cost) {
+			t.Errorf("simplified=%v: cost is %v, want %v", simple, got.Cost, want.Cost)
+		}
+		for k, m := range fused.Model {
+			for i, dw := range m.DW {
+				if g := ops.Model[k].DW[i]; !closeTo(float64(g), float64(dw)) {
+					t.Fatalf("simplified=%v: %s.DW[%d] is %v, want %v", simple, k, i, g, dw)
+				}
+			}
+		}
+
+		fused.Quantize()
+		ops.Quantize()
+		want = fused.Evaluate(sents)
+		got = ops.Evaluate(sents)
+		if !closeTo(got.Cost, want.Cost) {
+			t.Errorf("simplified=%v: quantized cost is %v, want %v", simple, got.Cost, want.Cost)
+		}
+	}
+}